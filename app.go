@@ -1,12 +1,20 @@
 package caddylura
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
 	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/proxy"
 	"github.com/xico42/caddy-lura/internal/lura"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 )
 
@@ -14,6 +22,17 @@ func init() {
 	caddy.RegisterModule(new(Lura))
 }
 
+// endpointMethods are the methods endpoints may be served with.
+var endpointMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
 // Lura implements a high-performance API Gateway using the Lura framework (https://luraproject.org/).
 //
 // This module provides advanced API gateway functionalities.
@@ -36,26 +55,66 @@ type Lura struct {
 	// The default url pattern is "/__debug/".
 	DebugEndpoint HelperEndpoint `json:"debug_endpoint,omitempty"`
 
+	// MockMode answers every backend call from a fixtures directory instead of the network,
+	// or records real backend responses into it. Useful for local development and CI.
+	MockMode *MockMode `json:"mock_mode,omitempty"`
+
 	// EchoEndpoint is a developer tool similar to DebugEndpoint but instead of logging, responses are printed directly.
 	// Useful for debugging configurations without verbose logging.
 	//
 	// The default url pattern is "/__echo/".
 	EchoEndpoint HelperEndpoint `json:"echo_endpoint,omitempty"`
 
+	// Router specifies the behaviour of the router matching the requests with the endpoints.
+	Router *Router `json:"router,omitempty"`
+
+	// Transport specifies the default settings of the HTTP transport used to reach the backends.
+	// Backends with their own Transport do not inherit any of these settings.
+	// gRPC backends only use its TLS settings, and WebSocket endpoints its TLS, dial_timeout, keep_alive
+	// and proxy settings.
+	Transport *Transport `json:"transport,omitempty"`
+
 	// handler is the internal HTTP handler for serving requests handled by the API Gateway module within Caddy.
-	handler caddyhttp.Handler
+	handler *lura.Handler
 }
 
 // Endpoint represents a public-facing gateway URL with specific configurations.
 type Endpoint struct {
 	// URLPattern defines the endpoint URL, supporting path parameters. It specifies the public API endpoint exposed by the gateway.
 	//
-	// Example: "/users/{id}/permissions"
+	// Path parameters may be constrained with a type, "int" or "uuid", or with a regular expression matching
+	// the whole segment: the endpoint only matches when the segment satisfies the constraint, and other
+	// endpoints are tried otherwise. Static segments take precedence over parameters, and constrained
+	// parameters over unconstrained ones.
+	//
+	// The last segment may be a catch-all parameter, e.g. "/legacy/{*rest}", matching the rest of the path,
+	// slashes included: backends with the URL pattern "/v1/{rest}" get "/v1/a/b" for "/legacy/a/b", and "/v1/"
+	// for "/legacy/". The forwarded path is cleaned and escaped. Endpoints matching the same path with a
+	// static segment or a parameter instead take precedence over it.
+	//
+	// Example: "/users/{id}/permissions", "/users/{id:int}", "/files/{name:[a-z0-9-]+}", "/legacy/{*rest}"
 	URLPattern string `json:"url_pattern,omitempty"`
 
-	// Method specifies the HTTP method for the endpoint. If not specified, "GET" is assumed.
+	// Method specifies the HTTP method for the endpoint. If neither Method nor Methods are specified, "GET" is assumed.
 	Method string `json:"method,omitempty"`
 
+	// Methods specifies further HTTP methods served by the endpoint, each of them with the same backends and settings.
+	// Backends without a Method of their own are called with the method of the request.
+	//
+	// GET endpoints answer HEAD requests as well, unless HEAD is served by another endpoint with the same URLPattern:
+	// the backends are called as for GET and the body of the response is discarded. Endpoints pulling pub/sub
+	// messages or mirroring requests to shadow backends do not answer HEAD requests, as they would not be harmless.
+	// OPTIONS requests are answered with the Allow header, unless OPTIONS is listed explicitly.
+	Methods []string `json:"methods,omitempty"`
+
+	// MatcherSetsRaw restricts the endpoint to the requests matched by any of the matcher sets, e.g. by header,
+	// query or client IP, so that several endpoints may share the same URLPattern and method.
+	// The first endpoint whose matchers match the request serves it, and the endpoint without matchers, if any,
+	// serves the rest. Requests matched by none of them are answered with 405 if an endpoint with another
+	// method matches, and with 404 otherwise.
+	MatcherSetsRaw caddyhttp.RawMatcherSets `json:"match,omitempty" caddy:"namespace=http.matchers"`
+	matcherSets    caddyhttp.MatcherSets
+
 	// ConcurrentCalls specifies the number of concurrent calls this endpoint makes to each backend.
 	// Controls the concurrency of backend requests to optimize performance.
 	ConcurrentCalls int `json:"concurrent_calls,omitempty"`
@@ -66,14 +125,57 @@ type Endpoint struct {
 	// CacheTTL specifies the cache duration for responses from this endpoint. Controls caching headers for client caching.
 	CacheTTL caddy.Duration `json:"cache_ttl,omitempty"`
 
+	// QueryStrings specifies the query strings of the client request forwarded to the backends.
+	// Use "*" to forward every query string. If not specified, none is forwarded, though backends may
+	// still reference query strings in their URLPattern with placeholders.
+	//
+	// Example: ["page", "limit"]
+	QueryStrings []string `json:"query_strings,omitempty"`
+
 	// Backends specifies the set of backend services that serve requests for this endpoint.
 	// Responses from multiple backends are aggregated based on rules defined in the gateway configuration.
 	Backends []Backend `json:"backends,omitempty"`
+
+	// Completeness specifies how the endpoint answers when some of its backends fail.
+	// If not specified, the endpoint answers with the data of the backends that succeeded.
+	Completeness *Completeness `json:"completeness,omitempty"`
+
+	// Transform reshapes the final merged response of the endpoint before it is rendered.
+	Transform *Transform `json:"transform,omitempty"`
+
+	// ResponseTemplate renders the endpoint response using a Go text/template instead of encoding it as JSON.
+	// Useful for emitting exact legacy shapes that cannot be expressed with mapping or transform.
+	ResponseTemplate *ResponseTemplate `json:"response_template,omitempty"`
+
+	// Static specifies static data merged into the endpoint response.
+	// Endpoints without backends are stubs: they answer with the static data only.
+	Static *StaticData `json:"static,omitempty"`
+
+	// WebSocket turns the endpoint into a WebSocket endpoint: the client connection is upgraded and messages
+	// are proxied to and from a WebSocket connection opened with the single backend of the endpoint.
+	// The backend URLPattern may use path parameters and placeholders as usual, and "http" and "https"
+	// hosts are dialed as "ws" and "wss" respectively. The endpoint Timeout applies to the handshakes only.
+	WebSocket *WebSocket `json:"websocket,omitempty"`
+
+	// Stream turns the endpoint into a Server-Sent Events endpoint: streams are opened with every backend and
+	// their events are multiplexed into a single event stream for the client, tagged with the backend Group.
+	// Backends may answer with Server-Sent Events or with JSON lines. The endpoint Timeout bounds the time
+	// taken by each backend to start its stream.
+	Stream *Stream `json:"stream,omitempty"`
 }
 
 // Backend represents a backend service that handles requests for an endpoint.
 type Backend struct {
+	// Name identifies the backend, e.g. in mock mode fixtures.
+	// If not specified, the host (and port) of the first backend host is assumed.
+	Name string `json:"name,omitempty"`
+
 	// Host specifies the list of backend hosts. Requests are load balanced between these hosts.
+	//
+	// Besides network hosts, Unix sockets may be given as "unix//path/to/app.sock", as for Caddy's reverse proxy.
+	// The "caddy://<server>" host hands the requests directly to the routes of the named Caddy HTTP server,
+	// in-process, keeping the Host of the request received by the gateway. Requests routed back to the gateway
+	// by such servers are answered with 508 once they loop more than 8 times.
 	Host []string `json:"host,omitempty"`
 
 	// URLPattern specifies the URL pattern to locate the resource on the backend service.
@@ -95,6 +197,441 @@ type Backend struct {
 
 	// Method specifies the HTTP method used for requests to the backend service.
 	Method string `json:"method,omitempty"`
+
+	// Transform reshapes the backend response before AllowList, Mapping and Group are applied.
+	Transform *Transform `json:"transform,omitempty"`
+
+	// GraphQL turns the backend into a GraphQL backend: the configured operation is sent as a POST request
+	// to the backend and the "data" object of the answer is unwrapped before AllowList, Mapping and Group
+	// are applied. GraphQL errors are reported as backend errors.
+	GraphQL *GraphQL `json:"graphql,omitempty"`
+
+	// GRPC turns the backend into a gRPC backend: a unary method is invoked on the backend hosts, using a
+	// request message built from the JSON body, forwarded query strings and path parameters. The reply is decoded
+	// as JSON so that it can be merged with other backends, and gRPC status codes are mapped to HTTP statuses.
+	//
+	// Hosts using the "https" scheme are reached through TLS, with the TLS settings of the Transport, if any.
+	// Any other host is reached through plaintext HTTP/2.
+	GRPC *GRPC `json:"grpc,omitempty"`
+
+	// PubSub turns the backend into a queue: GET endpoints pull a single message from the subscription,
+	// any other endpoint publishes the request body to the topic and answers with the message id.
+	// The Method of the backend does not change the mode.
+	// Pulled messages are decoded like regular backend responses. Hosts are not used.
+	PubSub *PubSub `json:"pubsub,omitempty"`
+
+	// Retry retries the calls failing with a network error or a retryable status code, waiting for an
+	// exponential backoff between attempts. Retries never go past the endpoint timeout.
+	Retry *Retry `json:"retry,omitempty"`
+
+	// Hedge sends GET and HEAD requests that are slow to be answered to another host of the backend as well,
+	// using whichever answers first and canceling the other one. Requires at least two hosts.
+	Hedge *Hedge `json:"hedge,omitempty"`
+
+	// Timeout specifies the timeout of each call to the backend. When it expires, the endpoint answers with the
+	// responses of the other backends, flagged as incomplete. The endpoint Timeout still applies.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	// Transport specifies the settings of the HTTP transport used to reach the backend.
+	// If not specified, the default transport of the module is used.
+	// gRPC backends only support its TLS settings, and WebSocket endpoints its TLS, dial_timeout, keep_alive
+	// and proxy settings.
+	Transport *Transport `json:"transport,omitempty"`
+
+	// Variants splits the traffic of the backend between versions of it, e.g. for canary releases.
+	// Each request is sent to a single variant, chosen according to the weights, using the hosts and URLPattern
+	// of the variant. The name of the chosen variant is available as the {lura.variant.<backend name>}
+	// placeholder and as the VariantHeader response header.
+	Variants []Variant `json:"variants,omitempty"`
+
+	// Sticky specifies the key assigning requests to variants, so that the same client is consistently sent
+	// to the same variant. If not specified, requests are assigned at random.
+	Sticky *Sticky `json:"sticky,omitempty"`
+
+	// VariantHeader specifies the response header set to the name of the variant chosen for the request.
+	// If not specified, "X-Lura-Variant" is assumed.
+	VariantHeader string `json:"variant_header,omitempty"`
+
+	// Required specifies whether the requests of the endpoint fail when the backend does not answer them
+	// successfully, true marking the backend as required and false as optional.
+	// If not specified, the Completeness policy of the endpoint decides.
+	Required *bool `json:"required,omitempty"`
+
+	// Fallback specifies the data merged in place of the response of the backend when it fails or exceeds its
+	// Timeout, so that clients get the expected properties anyway. The response is flagged as incomplete, and
+	// the names of the backends served from their fallback are available as the {lura.fallback} placeholder
+	// and the X-Lura-Fallback response header. Fallback data does not satisfy Required backends.
+	Fallback *Fallback `json:"fallback,omitempty"`
+
+	// Shadow turns the backend into a shadow backend, e.g. to validate a migration with real traffic:
+	// it is sent a copy of the requests in the background, and its responses are never merged into the
+	// endpoint response, neither delaying it nor affecting its completeness.
+	Shadow *Shadow `json:"shadow,omitempty"`
+}
+
+// Fallback represents the data merged in place of the response of a failed backend.
+type Fallback struct {
+	// Data specifies the static data merged when no previous response of the backend is kept.
+	// It is placed under the Group of the backend, if any.
+	Data map[string]interface{} `json:"data,omitempty"`
+
+	// LastSuccess specifies whether the last successful response of the backend is kept in memory for each Key,
+	// and merged in place of the failed responses with the same Key.
+	LastSuccess bool `json:"last_success,omitempty"`
+
+	// Size specifies the maximum number of responses kept, the least recently used being dropped first.
+	// If not specified, 1000 is assumed.
+	Size int `json:"size,omitempty"`
+
+	// Key specifies the key of the responses kept, usually made of placeholders.
+	// If not specified, the resolved URLPattern of the backend is used, along with the forwarded query strings
+	// and the forwarded client headers, such as Authorization.
+	//
+	// Example: "{http.request.header.X-Tenant-Id}/{id}"
+	Key string `json:"key,omitempty"`
+}
+
+// Completeness represents the policy of an endpoint regarding its failed backends.
+type Completeness struct {
+	// Policy specifies either "partial", answering with the data of the backends that succeeded,
+	// flagged as incomplete, unless a backend marked as required failed; or "require_all", failing
+	// the request if any backend not marked as optional failed.
+	// If not specified, "partial" is assumed.
+	Policy string `json:"policy,omitempty"`
+
+	// Status specifies the status of the responses failed because of a required backend.
+	// If not specified, 502 is assumed.
+	Status int `json:"status,omitempty"`
+}
+
+// Shadow represents the settings of a shadow backend.
+type Shadow struct {
+	// SampleRate specifies the percentage of the requests copied to the backend. Zero pauses the copies.
+	// If not specified, 100 is assumed.
+	SampleRate *float64 `json:"sample_rate,omitempty"`
+
+	// Timeout specifies the timeout of the calls to the backend, which outlive the request.
+	// If not specified, the endpoint timeout is assumed.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	// LogDiff specifies whether the properties of the backend responses that differ from those of the Primary
+	// backend are logged.
+	LogDiff bool `json:"log_diff,omitempty"`
+
+	// Primary specifies the name of the backend whose responses are compared with those of the shadow backend
+	// when LogDiff is set, as they are before being merged with the other backends and transformed.
+	// If not specified, the only backend of the endpoint that is not a shadow backend is assumed.
+	Primary string `json:"primary,omitempty"`
+}
+
+// Variant represents a version of a backend receiving a share of its traffic.
+type Variant struct {
+	// Name identifies the variant, e.g. "v2".
+	Name string `json:"name,omitempty"`
+
+	// Weight specifies the share of the traffic sent to the variant, relative to the other variants.
+	// Variants with a zero weight receive no traffic.
+	Weight int `json:"weight,omitempty"`
+
+	// Host specifies the hosts of the variant. If not specified, the hosts of the backend are assumed.
+	Host []string `json:"host,omitempty"`
+
+	// URLPattern specifies the URL pattern of the resource on the variant.
+	// If not specified, the URLPattern of the backend is assumed.
+	URLPattern string `json:"url_pattern,omitempty"`
+}
+
+// Sticky represents the key assigning requests to backend variants. Requests with the same key are sent
+// to the same variant as long as the weights do not change. Requests without a key are assigned at random.
+type Sticky struct {
+	// Source specifies where the key is taken from: "cookie", "header" or "client_ip".
+	Source string `json:"source,omitempty"`
+
+	// Name specifies the name of the cookie or header holding the key.
+	Name string `json:"name,omitempty"`
+}
+
+// GraphQL represents a GraphQL operation sent to a backend.
+type GraphQL struct {
+	// Query specifies the inline GraphQL query or mutation. Either Query or QueryFile must be set.
+	//
+	// Example: "query($id: ID!) { user(id: $id) { id name } }"
+	Query string `json:"query,omitempty"`
+
+	// QueryFile specifies the path of a file holding the GraphQL query or mutation.
+	QueryFile string `json:"query_file,omitempty"`
+
+	// OperationName selects the operation to run when the query document holds several of them.
+	OperationName string `json:"operation_name,omitempty"`
+
+	// Variables specifies the variables of the operation. String values may reference path parameters,
+	// query strings and any other Caddy placeholder, and are sent as strings. A value made of a single
+	// placeholder suffixed with ":int", ":float" or ":bool" is sent with that type, or as null when empty.
+	// For requests with a JSON object body, its properties override these variables.
+	//
+	// Example: {"id": "{id}", "limit": "{http.request.uri.query.limit:int}"}
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Transform represents an expression used to compute projections, filters and restructured
+// documents out of a response.
+//
+// Results that are not JSON objects are wrapped: arrays are moved to the "collection" property
+// and any other value to the "content" property.
+type Transform struct {
+	// Language specifies the language of the expression, either "jmespath" or "jsonpath".
+	// If not specified, "jmespath" is assumed.
+	Language string `json:"language,omitempty"`
+
+	// Expression is the query evaluated against the response.
+	//
+	// Example: "{id: id, roles: permissions[*].name}"
+	Expression string `json:"expression,omitempty"`
+}
+
+// ResponseTemplate represents a Go text/template (https://pkg.go.dev/text/template) used to render an endpoint response.
+//
+// The template is executed against an object exposing the merged response data as `.Data`, the completeness
+// of the response as `.IsComplete`, and request placeholders through `.Placeholder`. All sprig functions
+// (https://masterminds.github.io/sprig/) are available.
+//
+// Example: `{"user": {{ .Data.name | quote }}, "tenant": {{ .Placeholder "http.request.header.X-Tenant-Id" | quote }}}`
+type ResponseTemplate struct {
+	// Template specifies the inline template source. Either Template or File must be set.
+	Template string `json:"template,omitempty"`
+
+	// File specifies the path of a file holding the template source.
+	File string `json:"file,omitempty"`
+
+	// ContentType specifies the Content-Type header of the rendered response.
+	// If not specified, "application/json" is assumed.
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// StaticData represents static JSON data merged into an endpoint response.
+type StaticData struct {
+	// Data specifies the properties added to the response. Existing properties with the same name are overwritten.
+	Data map[string]interface{} `json:"data,omitempty"`
+
+	// Strategy specifies when the data is merged: "always", "success" (all backends succeeded),
+	// "errored" (some backend failed), "complete" or "incomplete".
+	// If not specified, "always" is assumed.
+	Strategy string `json:"strategy,omitempty"`
+}
+
+// MockMode represents the fixtures used to answer backend calls without reaching the network.
+//
+// Fixtures are stored as JSON files keyed by backend name, method, resolved path and request body:
+// "<fixtures>/<backend name>/<METHOD>/<escaped path and query>-<sha256 of the path, query and body>.json",
+// the escaped path and query being truncated to 128 bytes.
+type MockMode struct {
+	// Mode specifies whether fixtures are served ("replay") or captured from the real backends ("record").
+	// If not specified, "replay" is assumed.
+	Mode string `json:"mode,omitempty"`
+
+	// Fixtures specifies the directory holding the fixtures.
+	Fixtures string `json:"fixtures,omitempty"`
+}
+
+// GRPC represents a unary gRPC method invoked on a backend.
+type GRPC struct {
+	// Protoset specifies the path of a descriptor set file describing the service, as generated by
+	// `protoc --include_imports --descriptor_set_out=<file>`.
+	Protoset string `json:"protoset,omitempty"`
+
+	// Method specifies the fully qualified method name.
+	//
+	// Example: "users.v1.UserService/GetUser"
+	Method string `json:"method,omitempty"`
+}
+
+// WebSocket represents the settings of a WebSocket endpoint.
+type WebSocket struct {
+	// HeadersToPass specifies the client headers forwarded to the backend and to the auth hook.
+	// Use "*" to forward every header. If not specified, only "Content-Type" is forwarded.
+	// Requested subprotocols are always forwarded.
+	HeadersToPass []string `json:"headers_to_pass,omitempty"`
+
+	// Auth specifies the URL of a service called with a GET request and the forwarded headers before
+	// the connection is upgraded. Answers outside the 2xx range reject the handshake with the same status.
+	// The service is reached with the Transport of the backend, within the endpoint Timeout.
+	// Caddy placeholders may be used.
+	//
+	// Example: "http://auth:8080/check?room={room}"
+	Auth string `json:"auth,omitempty"`
+
+	// AllowedOrigins specifies the values of the Origin header of the handshakes accepted, "*" accepting any
+	// origin. If not specified, browsers may only connect from pages served by the same host as the endpoint.
+	// Other handshakes are answered with 403.
+	//
+	// Example: ["https://app.example.com"]
+	AllowedOrigins []string `json:"allowed_origins,omitempty"`
+
+	// MaxMessageSize specifies the maximum size in bytes of a message sent by the client or the backend.
+	// Larger messages close the connection with the 1009 (message too big) status. Zero means no limit.
+	MaxMessageSize int64 `json:"max_message_size,omitempty"`
+
+	// MaxConnections specifies the maximum number of connections open at the same time on the endpoint.
+	// Further handshakes are answered with 503. Zero means no limit.
+	MaxConnections int `json:"max_connections,omitempty"`
+}
+
+// Stream represents the settings of a Server-Sent Events endpoint.
+//
+// Events are tagged with the Group of the backend they come from, or with its Name if it has no group:
+// the event type is the tag for unnamed events and "<tag>.<event type>" for named ones.
+type Stream struct {
+	// HeadersToPass specifies the client headers forwarded to the backends.
+	// Use "*" to forward every header. If not specified, only "Content-Type" is forwarded.
+	HeadersToPass []string `json:"headers_to_pass,omitempty"`
+
+	// Heartbeat specifies the interval between the comments sent to keep the client connection alive.
+	// If not specified, 15 seconds are assumed. Negative values disable the heartbeat.
+	Heartbeat caddy.Duration `json:"heartbeat,omitempty"`
+
+	// Reconnect specifies the delay before reopening the stream of a backend that dropped or failed.
+	// The last event id received from the backend is sent in the Last-Event-ID header.
+	// If not specified, 1 second is assumed. Backends may override it with the "retry" field.
+	Reconnect caddy.Duration `json:"reconnect,omitempty"`
+}
+
+// PubSub represents a topic and a subscription reached through Go CDK URLs (https://gocloud.dev/howto/pubsub/).
+//
+// The "mem", "nats", "kafka" and "rabbit" schemes are supported, the connection to the broker being configured
+// through the environment variables of each driver (e.g. NATS_SERVER_URL, KAFKA_BROKERS or RABBIT_SERVER_URL).
+type PubSub struct {
+	// Topic specifies the URL of the topic messages are published to. Required for non-GET endpoints.
+	//
+	// Example: "nats://orders"
+	Topic string `json:"topic,omitempty"`
+
+	// Subscription specifies the URL of the subscription messages are pulled from. Required for GET endpoints.
+	//
+	// Example: "kafka://order-readers?topic=orders"
+	Subscription string `json:"subscription,omitempty"`
+
+	// Metadata specifies the metadata of published messages. Values may reference path parameters, request headers
+	// and any other Caddy placeholder. The id generated for each message is added under the "message_id" key.
+	//
+	// Example: {"tenant": "{http.request.header.X-Tenant-Id}", "order": "{id}"}
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Retry represents the retry policy of a backend.
+//
+// Only calls made with idempotent methods (GET, HEAD, OPTIONS, TRACE, PUT and DELETE) are retried, unless
+// NonIdempotent is set. Request bodies are buffered so that they can be replayed.
+type Retry struct {
+	// MaxAttempts specifies the maximum number of calls made to the backend, the first one included.
+	// If not specified, 3 attempts are made.
+	MaxAttempts int `json:"max_attempts,omitempty"`
+
+	// Backoff specifies the delay before the first retry, doubled on each further retry.
+	// If not specified, 100 milliseconds are assumed.
+	Backoff caddy.Duration `json:"backoff,omitempty"`
+
+	// MaxBackoff specifies the maximum delay between two attempts. If not specified, 2 seconds are assumed.
+	MaxBackoff caddy.Duration `json:"max_backoff,omitempty"`
+
+	// Jitter specifies the fraction of each delay that is randomized, between 0 and 1.
+	// For instance, 0.5 spreads a 100ms delay between 50ms and 100ms.
+	Jitter float64 `json:"jitter,omitempty"`
+
+	// Statuses specifies the backend status codes that are retried. Network errors are always retried.
+	// If not specified, 502, 503 and 504 are retried.
+	Statuses []int `json:"statuses,omitempty"`
+
+	// NonIdempotent specifies whether calls made with non-idempotent methods, such as POST, are retried too.
+	NonIdempotent bool `json:"non_idempotent,omitempty"`
+}
+
+// Hedge represents the hedging policy of a backend.
+type Hedge struct {
+	// Delay specifies the time waited for an answer before hedging.
+	// If not specified, the delay is the Percentile of the latencies observed for the backend.
+	Delay caddy.Duration `json:"delay,omitempty"`
+
+	// Percentile specifies the latency percentile used as delay when no fixed Delay is set.
+	// No request is hedged until enough latencies are observed. If not specified, 95 is assumed.
+	Percentile float64 `json:"percentile,omitempty"`
+
+	// Budget specifies the maximum percentage of requests that are hedged, so that hedging cannot
+	// double the load of a struggling backend. If not specified, 10 is assumed.
+	Budget float64 `json:"budget,omitempty"`
+}
+
+// Router represents the behaviour of the router matching the requests with the endpoints.
+type Router struct {
+	// RedirectTrailingSlash specifies whether requests are redirected to the path with (or without) a trailing slash
+	// when only that path matches an endpoint. GET requests are redirected with 301, others with 308.
+	// If not specified, true is assumed.
+	RedirectTrailingSlash *bool `json:"redirect_trailing_slash,omitempty"`
+
+	// RedirectFixedPath specifies whether requests are redirected to the cleaned, case-insensitive match of their
+	// path when it matches an endpoint, e.g. "/FOO" and "/..//foo" to "/foo". If not specified, true is assumed.
+	RedirectFixedPath *bool `json:"redirect_fixed_path,omitempty"`
+
+	// HandleMethodNotAllowed specifies whether requests matching an endpoint with another method are answered
+	// with 405 and the Allow header, rather than with 404. If not specified, true is assumed.
+	HandleMethodNotAllowed *bool `json:"handle_method_not_allowed,omitempty"`
+
+	// HandleOPTIONS specifies whether OPTIONS requests are answered automatically with the Allow header,
+	// unless an endpoint serves OPTIONS explicitly. If not specified, true is assumed.
+	HandleOPTIONS *bool `json:"handle_options,omitempty"`
+
+	// NotFound specifies the JSON body of the answers to the requests matching no endpoint.
+	//
+	// Example: {"error": "not found"}
+	NotFound json.RawMessage `json:"not_found,omitempty"`
+
+	// MethodNotAllowed specifies the JSON body of the answers to the requests matching an endpoint with
+	// another method.
+	MethodNotAllowed json.RawMessage `json:"method_not_allowed,omitempty"`
+
+	// RecoverPanics specifies whether panics raised while serving a request are logged and answered with 500,
+	// instead of aborting the connection. If not specified, true is assumed.
+	RecoverPanics *bool `json:"recover_panics,omitempty"`
+}
+
+// Transport represents the settings of the HTTP transport used to reach a backend.
+// Each transport has its own pool of connections.
+type Transport struct {
+	// DialTimeout specifies the maximum time taken to open a connection with the backend.
+	DialTimeout caddy.Duration `json:"dial_timeout,omitempty"`
+
+	// ResponseHeaderTimeout specifies the maximum time waited for the response headers,
+	// once the request is written.
+	ResponseHeaderTimeout caddy.Duration `json:"response_header_timeout,omitempty"`
+
+	// TLSHandshakeTimeout specifies the maximum time taken by the TLS handshake.
+	// If not specified, 10 seconds are assumed.
+	TLSHandshakeTimeout caddy.Duration `json:"tls_handshake_timeout,omitempty"`
+
+	// TLS specifies the TLS settings used with "https" hosts, such as client certificates, trusted
+	// certificate authorities or the server name, as for the transport of Caddy's reverse proxy.
+	TLS *reverseproxy.TLSConfig `json:"tls,omitempty"`
+
+	// MaxIdleConns specifies the maximum number of idle connections kept across all hosts.
+	// If not specified, 100 connections are kept.
+	MaxIdleConns int `json:"max_idle_conns,omitempty"`
+
+	// MaxIdleConnsPerHost specifies the maximum number of idle connections kept per host.
+	// If not specified, 2 connections are kept.
+	MaxIdleConnsPerHost int `json:"max_idle_conns_per_host,omitempty"`
+
+	// KeepAlive specifies the interval between TCP keep-alive probes. Negative values disable keep-alive,
+	// connections are then never reused. If not specified, 30 seconds are assumed.
+	KeepAlive caddy.Duration `json:"keep_alive,omitempty"`
+
+	// Versions specifies the HTTP versions used with the backend: "1.1", "2", and "h2c" for HTTP/2
+	// over cleartext with "http" hosts. If not specified, "1.1" and "2" are used.
+	Versions []string `json:"versions,omitempty"`
+
+	// Proxy specifies the URL of the HTTP proxy that requests are sent through.
+	// If not specified, the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are honored.
+	//
+	// Example: "http://proxy.internal:3128"
+	Proxy string `json:"proxy,omitempty"`
 }
 
 // HelperEndpoint represents a helper endpoint for developers within the Caddy web server.
@@ -107,30 +644,255 @@ type HelperEndpoint struct {
 }
 
 func (l *Lura) Provision(ctx caddy.Context) error {
+	defaultTransport, err := l.Transport.options(ctx)
+	if err != nil {
+		return fmt.Errorf("transport: %w", err)
+	}
+
+	upstreams := newLocalUpstreams()
+	renders := map[string]lura.Render{}
 	endpoints := make([]*config.EndpointConfig, 0, len(l.Endpoints))
-	for _, e := range l.Endpoints {
+	for i := range l.Endpoints {
+		if l.Endpoints[i].MatcherSetsRaw == nil {
+			continue
+		}
+		mods, err := ctx.LoadModule(&l.Endpoints[i], "MatcherSetsRaw")
+		if err != nil {
+			return fmt.Errorf("endpoint %s: loading matchers: %w", l.Endpoints[i].URLPattern, err)
+		}
+		err = l.Endpoints[i].matcherSets.FromInterface(mods)
+		if err != nil {
+			return fmt.Errorf("endpoint %s: %w", l.Endpoints[i].URLPattern, err)
+		}
+	}
+	for _, e := range l.endpointsByMethod() {
+		if !endpointMethods[e.Method] {
+			return fmt.Errorf("endpoint %s: unsupported method '%s'", e.URLPattern, e.Method)
+		}
+
+		pattern, err := parseEndpointPattern(e.URLPattern)
+		if err != nil {
+			return fmt.Errorf("endpoint %s: %w", e.URLPattern, err)
+		}
+
 		backends := make([]*config.Backend, 0, len(e.Backends))
 		for _, b := range e.Backends {
 			backendParams := newParamsSetFromPattern(b.URLPattern)
 
+			transform, err := b.Transform.compile()
+			if err != nil {
+				return fmt.Errorf("endpoint %s: backend %s: %w", e.URLPattern, b.URLPattern, err)
+			}
+
+			graphQL, err := b.GraphQL.options()
+			if err != nil {
+				return fmt.Errorf("endpoint %s: backend %s: %w", e.URLPattern, b.URLPattern, err)
+			}
+
+			grpc, err := b.GRPC.options()
+			if err != nil {
+				return fmt.Errorf("endpoint %s: backend %s: %w", e.URLPattern, b.URLPattern, err)
+			}
+
+			pubSub, err := b.PubSub.options(e.Method)
+			if err != nil {
+				return fmt.Errorf("endpoint %s: backend %s: %w", e.URLPattern, b.URLPattern, err)
+			}
+
+			transport := defaultTransport
+			if b.Transport != nil {
+				transport, err = b.Transport.options(ctx)
+				if err != nil {
+					return fmt.Errorf("endpoint %s: backend %s: transport: %w", e.URLPattern, b.URLPattern, err)
+				}
+			}
+
+			if b.Hedge != nil && len(b.Host) < 2 {
+				return fmt.Errorf("endpoint %s: backend %s: hedging requires at least two hosts", e.URLPattern, b.URLPattern)
+			}
+
+			if b.Fallback != nil {
+				if b.Fallback.Data == nil && !b.Fallback.LastSuccess {
+					return fmt.Errorf("endpoint %s: backend %s: fallback requires either data or last_success", e.URLPattern, b.URLPattern)
+				}
+				if b.Fallback.Size < 0 {
+					return fmt.Errorf("endpoint %s: backend %s: negative fallback size", e.URLPattern, b.URLPattern)
+				}
+				if b.Shadow != nil {
+					return fmt.Errorf("endpoint %s: backend %s: shadow backends cannot have a fallback", e.URLPattern, b.URLPattern)
+				}
+			}
+
+			if b.Shadow != nil && b.Required != nil && *b.Required {
+				return fmt.Errorf("endpoint %s: backend %s: shadow backends cannot be required", e.URLPattern, b.URLPattern)
+			}
+
+			if b.Shadow != nil && b.Shadow.SampleRate != nil && (*b.Shadow.SampleRate < 0 || *b.Shadow.SampleRate > 100) {
+				return fmt.Errorf("endpoint %s: backend %s: shadow sample rate should be a percentage between 0 and 100", e.URLPattern, b.URLPattern)
+			}
+
+			shadowPrimary, err := e.shadowPrimary(b.Shadow)
+			if err != nil {
+				return fmt.Errorf("endpoint %s: backend %s: %w", e.URLPattern, b.URLPattern, err)
+			}
+
+			split, variantHosts, err := b.split(upstreams, pattern.catchAll)
+			if err != nil {
+				return fmt.Errorf("endpoint %s: backend %s: %w", e.URLPattern, b.URLPattern, err)
+			}
+			if split != nil && (b.Hedge != nil || grpc != nil || pubSub != nil) {
+				return fmt.Errorf("endpoint %s: backend %s: variants cannot be used with hedging, grpc or pubsub", e.URLPattern, b.URLPattern)
+			}
+
+			if unsupported := b.Transport.unsupported("tls"); grpc != nil && len(unsupported) > 0 {
+				return fmt.Errorf("endpoint %s: backend %s: grpc backends do not support the transport settings: %s", e.URLPattern, b.URLPattern, strings.Join(unsupported, ", "))
+			}
+
+			if grpc != nil && (hasHost(b.Host, isUnixSocket) || hasHost(b.Host, isCaddyServer)) {
+				return fmt.Errorf("endpoint %s: backend %s: grpc backends cannot reach unix sockets or caddy servers", e.URLPattern, b.URLPattern)
+			}
+
+			hosts := upstreams.hosts(b.Host)
+			if pubSub != nil && len(hosts) == 0 {
+				// lura's load balancer requires a host, pubsub backends get a placeholder that is never reached
+				hosts = []string{"http://pubsub"}
+			}
+			if split != nil {
+				// the host picked by lura's load balancer is replaced by one of the chosen variant
+				hosts = variantHosts
+			}
+
 			backends = append(backends, &config.Backend{
-				Host: b.Host,
+				Host: hosts,
 				// ignore lura's placeholder processing, so that we may depend upon caddy's replacer only
-				URLPattern: processBackendUrlPattern(b.URLPattern, backendParams),
+				URLPattern: processBackendUrlPattern(joinCatchAll(b.URLPattern, pattern.catchAll), backendParams),
 				AllowList:  b.AllowList,
 				Mapping:    b.Mapping,
 				Group:      b.Group,
 				Method:     b.Method,
+				ExtraConfig: config.ExtraConfig{
+					lura.Namespace: lura.BackendOptions{
+						Name:      b.name(),
+						Transform: transform,
+						GraphQL:   graphQL,
+						GRPC:      grpc,
+						PubSub:    pubSub,
+						Retry:     b.Retry.options(),
+						Hedge:     b.Hedge.options(),
+						Timeout:   time.Duration(b.Timeout),
+						Transport: transport,
+						Split:     split,
+						Shadow:    b.Shadow.options(shadowPrimary),
+						Required:  b.required(e.Completeness),
+						Fallback:  b.Fallback.options(b.Group),
+					},
+				},
 			})
 		}
 
+		transform, err := e.Transform.compile()
+		if err != nil {
+			return fmt.Errorf("endpoint %s: %w", e.URLPattern, err)
+		}
+
+		switch e.Completeness.policy() {
+		case "partial", "require_all":
+		default:
+			return fmt.Errorf("endpoint %s: unsupported completeness policy '%s'", e.URLPattern, e.Completeness.Policy)
+		}
+		if e.Completeness != nil && e.Completeness.Status != 0 && (e.Completeness.Status < 400 || e.Completeness.Status > 599) {
+			return fmt.Errorf("endpoint %s: completeness status should be an error status, but got: %d", e.URLPattern, e.Completeness.Status)
+		}
+
+		if len(e.Backends) > 0 && e.shadowsOnly() {
+			return fmt.Errorf("endpoint %s: at least one backend must not be a shadow", e.URLPattern)
+		}
+
+		stub := len(backends) == 0
+		if stub {
+			if e.Static == nil {
+				return fmt.Errorf("endpoint %s: at least one backend or static data must be defined", e.URLPattern)
+			}
+			// lura requires at least one backend per endpoint, stubs get a placeholder that is never called
+			backends = append(backends, &config.Backend{})
+		}
+
+		if e.WebSocket != nil {
+			if len(e.Backends) != 1 {
+				return fmt.Errorf("endpoint %s: websocket endpoints must define exactly one backend", e.URLPattern)
+			}
+			if e.Method != "" && e.Method != http.MethodGet {
+				return fmt.Errorf("endpoint %s: websocket endpoints only support the GET method", e.URLPattern)
+			}
+			if hasHost(e.Backends[0].Host, isUnixSocket) || hasHost(e.Backends[0].Host, isCaddyServer) {
+				return fmt.Errorf("endpoint %s: websocket endpoints cannot reach unix sockets or caddy servers", e.URLPattern)
+			}
+			if len(e.Backends[0].Variants) > 0 {
+				return fmt.Errorf("endpoint %s: websocket endpoints do not support backend variants", e.URLPattern)
+			}
+			if e.Backends[0].Shadow != nil || e.Backends[0].Fallback != nil {
+				return fmt.Errorf("endpoint %s: websocket endpoints do not support shadow or fallback backends", e.URLPattern)
+			}
+			if unsupported := e.Backends[0].Transport.unsupported("tls", "dial_timeout", "keep_alive", "proxy"); len(unsupported) > 0 {
+				return fmt.Errorf("endpoint %s: websocket endpoints do not support the transport settings: %s", e.URLPattern, strings.Join(unsupported, ", "))
+			}
+		}
+
+		if e.Stream != nil {
+			if e.WebSocket != nil {
+				return fmt.Errorf("endpoint %s: websocket and stream cannot be used together", e.URLPattern)
+			}
+			if len(e.Backends) == 0 {
+				return fmt.Errorf("endpoint %s: stream endpoints must define at least one backend", e.URLPattern)
+			}
+			if e.Method != "" && e.Method != http.MethodGet {
+				return fmt.Errorf("endpoint %s: stream endpoints only support the GET method", e.URLPattern)
+			}
+			for _, b := range e.Backends {
+				if hasHost(b.Host, isCaddyServer) {
+					return fmt.Errorf("endpoint %s: stream endpoints cannot reach caddy servers", e.URLPattern)
+				}
+				if len(b.Variants) > 0 {
+					return fmt.Errorf("endpoint %s: stream endpoints do not support backend variants", e.URLPattern)
+				}
+				if b.Shadow != nil || b.Fallback != nil {
+					return fmt.Errorf("endpoint %s: stream endpoints do not support shadow or fallback backends", e.URLPattern)
+				}
+			}
+		}
+
+		outputEncoding, err := e.ResponseTemplate.register(renders, e.Method+" "+e.URLPattern)
+		if err != nil {
+			return fmt.Errorf("endpoint %s: %w", e.URLPattern, err)
+		}
+
+		extraConfig := config.ExtraConfig{
+			lura.Namespace: lura.EndpointOptions{
+				Transform:        transform,
+				Stub:             stub,
+				WebSocket:        e.WebSocket.options(),
+				Stream:           e.Stream.options(),
+				Constraints:      pattern.constraints,
+				CatchAll:         pattern.catchAll,
+				Matchers:         e.matcherSets,
+				IncompleteStatus: e.Completeness.status(),
+			},
+		}
+		if e.Static != nil {
+			extraConfig[proxy.Namespace] = e.Static.extraConfig()
+		}
+
 		endpoints = append(endpoints, &config.EndpointConfig{
-			Endpoint:        e.URLPattern,
+			Endpoint:        pattern.path,
 			Method:          e.Method,
 			ConcurrentCalls: e.ConcurrentCalls,
 			CacheTTL:        time.Duration(e.CacheTTL),
 			Timeout:         time.Duration(e.Timeout),
 			Backend:         backends,
+			OutputEncoding:  outputEncoding,
+			HeadersToPass:   e.headersToPass(),
+			QueryString:     e.QueryStrings,
+			ExtraConfig:     extraConfig,
 		})
 	}
 
@@ -144,7 +906,12 @@ func (l *Lura) Provision(ctx caddy.Context) error {
 		Echo:      l.EchoEndpoint.Enabled,
 	}
 
-	err := cfg.Init()
+	err = cfg.Init()
+	if err != nil {
+		return err
+	}
+
+	mock, err := l.MockMode.options()
 	if err != nil {
 		return err
 	}
@@ -160,6 +927,22 @@ func (l *Lura) Provision(ctx caddy.Context) error {
 		ZapLogger:     ctx.Logger(),
 		DebugPattern:  l.DebugEndpoint.URLPattern,
 		EchoPattern:   l.EchoEndpoint.URLPattern,
+		Mock:          mock,
+		Router:        l.Router.options(),
+		Renders:       renders,
+		UnixSockets:   upstreams.sockets,
+		Servers:       upstreams.servers,
+		ServerLookup: func(name string) (http.Handler, error) {
+			app, err := ctx.AppIfConfigured("http")
+			if err != nil {
+				return nil, err
+			}
+			server, ok := app.(*caddyhttp.App).Servers[name]
+			if !ok {
+				return nil, fmt.Errorf("caddy server '%s' not found", name)
+			}
+			return server, nil
+		},
 	})
 	if err != nil {
 		return err
@@ -170,10 +953,599 @@ func (l *Lura) Provision(ctx caddy.Context) error {
 	return nil
 }
 
+// name returns the backend name, defaulting to the host of its first upstream.
+func (b Backend) name() string {
+	hosts := b.Host
+	if len(hosts) == 0 && len(b.Variants) > 0 {
+		hosts = b.Variants[0].Host
+	}
+	if b.Name != "" || len(hosts) == 0 {
+		return b.Name
+	}
+
+	if u, err := url.Parse(hosts[0]); err == nil && u.Host != "" {
+		return u.Host
+	}
+
+	return hosts[0]
+}
+
+// split turns the variants of the backend into lura.SplitOptions, along with the hosts of all the variants.
+// A backend without variants results in nil lura.SplitOptions.
+func (b Backend) split(upstreams *localUpstreams, catchAll string) (*lura.SplitOptions, []string, error) {
+	if len(b.Variants) == 0 {
+		return nil, nil, nil
+	}
+
+	split := &lura.SplitOptions{
+		Variants: make([]lura.VariantOptions, 0, len(b.Variants)),
+		Key:      b.Sticky.key(),
+		Header:   b.VariantHeader,
+	}
+	if split.Header == "" {
+		split.Header = "X-Lura-Variant"
+	}
+
+	names := map[string]bool{}
+	hosts := make([]string, 0)
+	total := 0
+	for _, v := range b.Variants {
+		if v.Name == "" {
+			return nil, nil, errors.New("variants must be named")
+		}
+		if names[v.Name] {
+			return nil, nil, fmt.Errorf("duplicate variant '%s'", v.Name)
+		}
+		names[v.Name] = true
+
+		if v.Weight < 0 {
+			return nil, nil, fmt.Errorf("variant %s: negative weight", v.Name)
+		}
+		total += v.Weight
+
+		variantHosts := v.Host
+		if len(variantHosts) == 0 {
+			variantHosts = b.Host
+		}
+		if len(variantHosts) == 0 {
+			return nil, nil, fmt.Errorf("variant %s: no hosts", v.Name)
+		}
+		// lura cleans the hosts of its backends only, the variants are cleaned the same way
+		variantHosts, err := config.NewSafeURIParser().SafeCleanHosts(upstreams.hosts(variantHosts))
+		if err != nil {
+			return nil, nil, fmt.Errorf("variant %s: %w", v.Name, err)
+		}
+		hosts = append(hosts, variantHosts...)
+
+		urlPattern := v.URLPattern
+		if urlPattern == "" {
+			urlPattern = b.URLPattern
+		}
+
+		// the pattern is not seen by lura, it is resolved with caddy's replacer only
+		split.Variants = append(split.Variants, lura.VariantOptions{
+			Name:       v.Name,
+			Weight:     v.Weight,
+			Host:       variantHosts,
+			URLPattern: joinCatchAll(urlPattern, catchAll),
+		})
+	}
+	if total == 0 {
+		return nil, nil, errors.New("the weights of the variants add up to zero")
+	}
+
+	switch b.Sticky.source() {
+	case "", "client_ip":
+	case "cookie", "header":
+		if b.Sticky.Name == "" {
+			return nil, nil, fmt.Errorf("sticky %s: missing name", b.Sticky.Source)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported sticky source '%s'", b.Sticky.Source)
+	}
+
+	return split, hosts, nil
+}
+
+func (s *Sticky) source() string {
+	if s == nil {
+		return ""
+	}
+	return s.Source
+}
+
+// key returns the placeholder resolving into the key assigning requests to variants.
+func (s *Sticky) key() string {
+	switch s.source() {
+	case "cookie":
+		return "{http.request.cookie." + s.Name + "}"
+	case "header":
+		return "{http.request.header." + s.Name + "}"
+	case "client_ip":
+		return "{http.vars." + caddyhttp.ClientIPVarKey + "}"
+	}
+	return ""
+}
+
+// options loads the GraphQL operation and turns it into lura.GraphQLOptions.
+// A nil GraphQL results in nil lura.GraphQLOptions.
+func (g *GraphQL) options() (*lura.GraphQLOptions, error) {
+	if g == nil {
+		return nil, nil
+	}
+
+	query := g.Query
+	if g.QueryFile != "" {
+		b, err := os.ReadFile(g.QueryFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading graphql query: %w", err)
+		}
+		query = string(b)
+	}
+	if query == "" {
+		return nil, errors.New("graphql backends must define either an inline query or a query file")
+	}
+
+	return &lura.GraphQLOptions{
+		Query:         query,
+		OperationName: g.OperationName,
+		Variables:     g.Variables,
+	}, nil
+}
+
+// options validates the pub/sub backend for the given endpoint method and turns it into lura.PubSubOptions,
+// the method selecting whether messages are pulled or published. A nil PubSub results in nil lura.PubSubOptions.
+func (p *PubSub) options(method string) (*lura.PubSubOptions, error) {
+	if p == nil {
+		return nil, nil
+	}
+
+	pull := method == "" || method == http.MethodGet
+	if pull && p.Subscription == "" {
+		return nil, errors.New("pubsub backends of GET endpoints require a subscription")
+	}
+	if !pull && p.Topic == "" {
+		return nil, fmt.Errorf("pubsub backends of %s endpoints require a topic", method)
+	}
+
+	return &lura.PubSubOptions{
+		Pull:         pull,
+		Topic:        p.Topic,
+		Subscription: p.Subscription,
+		Metadata:     p.Metadata,
+	}, nil
+}
+
+// options turns the router settings into lura.RouterOptions.
+// A nil Router results in nil lura.RouterOptions, using the defaults.
+func (r *Router) options() *lura.RouterOptions {
+	if r == nil {
+		return nil
+	}
+
+	enabled := func(b *bool) bool {
+		return b == nil || *b
+	}
+
+	return &lura.RouterOptions{
+		RedirectTrailingSlash:  enabled(r.RedirectTrailingSlash),
+		RedirectFixedPath:      enabled(r.RedirectFixedPath),
+		HandleMethodNotAllowed: enabled(r.HandleMethodNotAllowed),
+		HandleOPTIONS:          enabled(r.HandleOPTIONS),
+		NotFound:               r.NotFound,
+		MethodNotAllowed:       r.MethodNotAllowed,
+		RecoverPanics:          enabled(r.RecoverPanics),
+	}
+}
+
+// options turns the transport settings into lura.TransportOptions, loading the TLS settings.
+// A nil Transport results in nil lura.TransportOptions, using the default transport.
+func (t *Transport) options(ctx caddy.Context) (*lura.TransportOptions, error) {
+	if t == nil {
+		return nil, nil
+	}
+
+	for _, v := range t.Versions {
+		if v != "1.1" && v != "2" && v != "h2c" {
+			return nil, fmt.Errorf("unsupported HTTP version '%s'", v)
+		}
+	}
+
+	opts := &lura.TransportOptions{
+		DialTimeout:           time.Duration(t.DialTimeout),
+		ResponseHeaderTimeout: time.Duration(t.ResponseHeaderTimeout),
+		TLSHandshakeTimeout:   time.Duration(t.TLSHandshakeTimeout),
+		MaxIdleConns:          t.MaxIdleConns,
+		MaxIdleConnsPerHost:   t.MaxIdleConnsPerHost,
+		KeepAlive:             time.Duration(t.KeepAlive),
+		Versions:              t.Versions,
+	}
+
+	if t.TLS != nil {
+		tlsConfig, err := t.TLS.MakeTLSClientConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLSConfig = tlsConfig
+		if t.TLS.HandshakeTimeout > 0 && opts.TLSHandshakeTimeout == 0 {
+			opts.TLSHandshakeTimeout = time.Duration(t.TLS.HandshakeTimeout)
+		}
+	}
+
+	if t.Proxy != "" {
+		proxyURL, err := url.Parse(t.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		opts.Proxy = proxyURL
+	}
+
+	return opts, nil
+}
+
+// unsupported lists the settings of the transport that are set but missing from supported, in their order of
+// declaration. A nil Transport has no settings.
+func (t *Transport) unsupported(supported ...string) []string {
+	if t == nil {
+		return nil
+	}
+
+	settings := []struct {
+		name string
+		set  bool
+	}{
+		{"dial_timeout", t.DialTimeout != 0},
+		{"response_header_timeout", t.ResponseHeaderTimeout != 0},
+		{"tls_handshake_timeout", t.TLSHandshakeTimeout != 0},
+		{"tls", t.TLS != nil},
+		{"max_idle_conns", t.MaxIdleConns != 0},
+		{"max_idle_conns_per_host", t.MaxIdleConnsPerHost != 0},
+		{"keep_alive", t.KeepAlive != 0},
+		{"versions", len(t.Versions) > 0},
+		{"proxy", t.Proxy != ""},
+	}
+
+	isSupported := make(map[string]bool, len(supported))
+	for _, name := range supported {
+		isSupported[name] = true
+	}
+
+	unsupported := make([]string, 0)
+	for _, setting := range settings {
+		if setting.set && !isSupported[setting.name] {
+			unsupported = append(unsupported, setting.name)
+		}
+	}
+	return unsupported
+}
+
+// required reports whether the backend is required by the completeness policy of its endpoint.
+func (b Backend) required(c *Completeness) bool {
+	if b.Shadow != nil {
+		return false
+	}
+	if b.Required != nil {
+		return *b.Required
+	}
+	return c.policy() == "require_all"
+}
+
+func (c *Completeness) policy() string {
+	if c == nil || c.Policy == "" {
+		return "partial"
+	}
+	return c.Policy
+}
+
+func (c *Completeness) status() int {
+	if c == nil {
+		return 0
+	}
+	return c.Status
+}
+
+// options turns the fallback into lura.FallbackOptions, placing the static data under group, if any.
+// A nil Fallback results in nil lura.FallbackOptions, disabling the fallback.
+func (f *Fallback) options(group string) *lura.FallbackOptions {
+	if f == nil {
+		return nil
+	}
+
+	data := f.Data
+	if data != nil && group != "" {
+		data = map[string]interface{}{group: data}
+	}
+
+	return &lura.FallbackOptions{
+		Data:        data,
+		LastSuccess: f.LastSuccess,
+		Size:        f.Size,
+		Key:         f.Key,
+	}
+}
+
+// options turns the shadow settings into lura.ShadowOptions, comparing the responses with those of the
+// primary backend.
+// A nil Shadow results in nil lura.ShadowOptions, for regular backends.
+func (s *Shadow) options(primary string) *lura.ShadowOptions {
+	if s == nil {
+		return nil
+	}
+
+	opts := &lura.ShadowOptions{
+		SampleRate: 100,
+		Timeout:    time.Duration(s.Timeout),
+		LogDiff:    s.LogDiff,
+		Primary:    primary,
+	}
+	if s.SampleRate != nil {
+		opts.SampleRate = *s.SampleRate
+	}
+
+	return opts
+}
+
+// options turns the hedging policy into lura.HedgeOptions.
+// A nil Hedge results in nil lura.HedgeOptions, disabling hedging.
+func (h *Hedge) options() *lura.HedgeOptions {
+	if h == nil {
+		return nil
+	}
+
+	return &lura.HedgeOptions{
+		Delay:      time.Duration(h.Delay),
+		Percentile: h.Percentile,
+		Budget:     h.Budget,
+	}
+}
+
+// options turns the retry policy into lura.RetryOptions.
+// A nil Retry results in nil lura.RetryOptions, disabling retries.
+func (r *Retry) options() *lura.RetryOptions {
+	if r == nil {
+		return nil
+	}
+
+	return &lura.RetryOptions{
+		MaxAttempts:   r.MaxAttempts,
+		Backoff:       time.Duration(r.Backoff),
+		MaxBackoff:    time.Duration(r.MaxBackoff),
+		Jitter:        r.Jitter,
+		Statuses:      r.Statuses,
+		NonIdempotent: r.NonIdempotent,
+	}
+}
+
+// options loads the protoset and turns the gRPC method into lura.GRPCOptions.
+// A nil GRPC results in nil lura.GRPCOptions.
+func (g *GRPC) options() (*lura.GRPCOptions, error) {
+	if g == nil {
+		return nil, nil
+	}
+
+	protoset, err := os.ReadFile(g.Protoset)
+	if err != nil {
+		return nil, fmt.Errorf("reading grpc protoset: %w", err)
+	}
+
+	method, err := lura.NewGRPCMethod(protoset, g.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lura.GRPCOptions{Method: method}, nil
+}
+
+// options turns the WebSocket settings into lura.WebSocketOptions.
+// A nil WebSocket results in nil lura.WebSocketOptions.
+func (w *WebSocket) options() *lura.WebSocketOptions {
+	if w == nil {
+		return nil
+	}
+
+	return &lura.WebSocketOptions{
+		MaxMessageSize: w.MaxMessageSize,
+		MaxConnections: w.MaxConnections,
+		Auth:           w.Auth,
+		AllowedOrigins: w.AllowedOrigins,
+	}
+}
+
+// endpointsByMethod returns a copy of the endpoints for each of their methods, so that lura,
+// which knows a single method per endpoint, handles every method with the same settings.
+func (l *Lura) endpointsByMethod() []Endpoint {
+	endpoints := make([]Endpoint, 0, len(l.Endpoints))
+	for _, e := range l.Endpoints {
+		for _, method := range e.methods() {
+			byMethod := e
+			byMethod.Method = method
+			byMethod.Methods = nil
+			endpoints = append(endpoints, byMethod)
+		}
+	}
+	return endpoints
+}
+
+// methods returns the distinct methods of the endpoint, in upper case. If none is specified, GET is assumed.
+func (e Endpoint) methods() []string {
+	methods := make([]string, 0, len(e.Methods)+1)
+	seen := map[string]bool{}
+	for _, m := range append([]string{e.Method}, e.Methods...) {
+		m = strings.ToUpper(m)
+		if m == "" || seen[m] {
+			continue
+		}
+		seen[m] = true
+		methods = append(methods, m)
+	}
+
+	if len(methods) == 0 {
+		methods = append(methods, http.MethodGet)
+	}
+
+	return methods
+}
+
+// shadowPrimary returns the name of the backend whose responses are compared with those of the shadow backend,
+// which is either its Primary or the only backend of the endpoint that is not a shadow backend.
+// It is empty when the responses are not compared.
+func (e Endpoint) shadowPrimary(s *Shadow) (string, error) {
+	if s == nil || (!s.LogDiff && s.Primary == "") {
+		return "", nil
+	}
+
+	var primaries []string
+	for _, b := range e.Backends {
+		if b.Shadow == nil && (s.Primary == "" || b.name() == s.Primary) {
+			primaries = append(primaries, b.name())
+		}
+	}
+
+	switch {
+	case len(primaries) == 1:
+		return primaries[0], nil
+	case s.Primary == "":
+		return "", errors.New("shadow backends logging their differences require a primary when the endpoint has several backends")
+	case len(primaries) == 0:
+		return "", fmt.Errorf("shadow primary '%s' is not a backend of the endpoint", s.Primary)
+	default:
+		return "", fmt.Errorf("shadow primary '%s' names several backends, which should be given distinct names", s.Primary)
+	}
+}
+
+// shadowsOnly reports whether all the backends of the endpoint are shadow backends.
+func (e Endpoint) shadowsOnly() bool {
+	for _, b := range e.Backends {
+		if b.Shadow == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// headersToPass returns the headers forwarded by the endpoint, nil meaning lura's defaults.
+func (e Endpoint) headersToPass() []string {
+	switch {
+	case e.WebSocket != nil:
+		return e.WebSocket.HeadersToPass
+	case e.Stream != nil:
+		return e.Stream.HeadersToPass
+	default:
+		return nil
+	}
+}
+
+// options turns the stream settings into lura.StreamOptions.
+// A nil Stream results in nil lura.StreamOptions.
+func (s *Stream) options() *lura.StreamOptions {
+	if s == nil {
+		return nil
+	}
+
+	return &lura.StreamOptions{
+		Heartbeat: time.Duration(s.Heartbeat),
+		Reconnect: time.Duration(s.Reconnect),
+	}
+}
+
+// options validates the mock mode and turns it into lura.MockOptions.
+// A nil MockMode results in nil lura.MockOptions, disabling the mock mode.
+func (m *MockMode) options() (*lura.MockOptions, error) {
+	if m == nil {
+		return nil, nil
+	}
+
+	if m.Fixtures == "" {
+		return nil, errors.New("mock mode requires a fixtures directory")
+	}
+
+	switch m.Mode {
+	case "", "replay":
+		return &lura.MockOptions{Dir: m.Fixtures}, nil
+	case "record":
+		return &lura.MockOptions{Dir: m.Fixtures, Record: true}, nil
+	default:
+		return nil, fmt.Errorf("unsupported mock mode '%s'", m.Mode)
+	}
+}
+
+// compile validates the transform expression and turns it into a lura.Transformer.
+// A nil Transform results in a nil lura.Transformer.
+func (t *Transform) compile() (lura.Transformer, error) {
+	if t == nil {
+		return nil, nil
+	}
+
+	transformer, err := lura.NewTransformer(t.Language, t.Expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transform: %w", err)
+	}
+
+	return transformer, nil
+}
+
+// extraConfig returns the static data in the format expected by lura's static proxy middleware.
+func (s *StaticData) extraConfig() map[string]interface{} {
+	strategy := s.Strategy
+	if strategy == "" {
+		strategy = "always"
+	}
+
+	return map[string]interface{}{
+		"static": map[string]interface{}{
+			"data":     s.Data,
+			"strategy": strategy,
+		},
+	}
+}
+
+// register parses the template and adds it to renders, returning the output encoding that selects it.
+// A nil ResponseTemplate results in an empty output encoding.
+func (t *ResponseTemplate) register(renders map[string]lura.Render, name string) (string, error) {
+	if t == nil {
+		return "", nil
+	}
+
+	source := t.Template
+	if t.File != "" {
+		b, err := os.ReadFile(t.File)
+		if err != nil {
+			return "", fmt.Errorf("reading response template: %w", err)
+		}
+		source = string(b)
+	}
+	if source == "" {
+		return "", errors.New("response template must define either an inline template or a file")
+	}
+
+	render, err := lura.NewTemplateRender(name, source, t.ContentType)
+	if err != nil {
+		return "", fmt.Errorf("invalid response template: %w", err)
+	}
+
+	// endpoints sharing a route and told apart by their matchers need their own render each
+	outputEncoding := "template:" + name
+	for i := 2; renders[outputEncoding] != nil; i++ {
+		outputEncoding = fmt.Sprintf("template:%s #%d", name, i)
+	}
+	renders[outputEncoding] = render
+
+	return outputEncoding, nil
+}
+
 func (l *Lura) ServeHTTP(rw http.ResponseWriter, req *http.Request, next caddyhttp.Handler) error {
 	return l.handler.ServeHTTP(rw, req)
 }
 
+// Cleanup releases the connections opened with the backends, e.g. when the config is reloaded.
+func (l *Lura) Cleanup() error {
+	if l.handler == nil {
+		return nil
+	}
+
+	return l.handler.Close()
+}
+
 func (*Lura) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
 		ID: "http.handlers.lura",
@@ -185,7 +1557,8 @@ func (*Lura) CaddyModule() caddy.ModuleInfo {
 
 // Interface guards
 var (
-	_ caddy.Provisioner = (*Lura)(nil)
+	_ caddy.Provisioner  = (*Lura)(nil)
+	_ caddy.CleanerUpper = (*Lura)(nil)
 	// _ caddy.Validator             = (*Middleware)(nil)
 	_ caddyhttp.MiddlewareHandler = (*Lura)(nil)
 	_ caddyfile.Unmarshaler       = (*Lura)(nil)
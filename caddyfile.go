@@ -1,12 +1,18 @@
 package caddylura
 
 import (
+	"encoding/json"
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
+	"github.com/caddyserver/caddy/v2/modules/caddytls"
+	"github.com/dustin/go-humanize"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func init() {
@@ -68,6 +74,34 @@ func (l *Lura) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 			}
 			break
 
+		case "transport":
+			l.Transport, err = unmarshalTransport(d)
+			if err != nil {
+				return err
+			}
+			break
+
+		case "router":
+			l.Router, err = unmarshalRouter(d)
+			if err != nil {
+				return err
+			}
+			break
+
+		case "mock_mode":
+			args := d.RemainingArgs()
+			if len(args) != 2 {
+				return d.ArgErr()
+			}
+			if args[0] != "replay" && args[0] != "record" {
+				return d.Errf("mock mode should be either 'replay' or 'record', but got: '%s'", args[0])
+			}
+			l.MockMode = &MockMode{
+				Mode:     args[0],
+				Fixtures: args[1],
+			}
+			break
+
 		default:
 			return d.Errf("unrecognized subdirective %s", d.Val())
 		}
@@ -116,6 +150,31 @@ func unmarshalEndpoint(d *caddyfile.Dispenser) (e Endpoint, err error) {
 			}
 			break
 
+		case "methods":
+			e.Methods = d.RemainingArgs()
+			if len(e.Methods) == 0 {
+				err = d.ArgErr()
+				return
+			}
+			break
+
+		case "query_strings":
+			e.QueryStrings = d.RemainingArgs()
+			if len(e.QueryStrings) == 0 {
+				err = d.ArgErr()
+				return
+			}
+			break
+
+		case "match":
+			var set caddy.ModuleMap
+			set, err = caddyhttp.ParseCaddyfileNestedMatcherSet(d)
+			if err != nil {
+				return
+			}
+			e.MatcherSetsRaw = append(e.MatcherSetsRaw, set)
+			break
+
 		case "backend":
 			var b Backend
 			b, err = unmarshalBackend(d)
@@ -124,6 +183,13 @@ func unmarshalEndpoint(d *caddyfile.Dispenser) (e Endpoint, err error) {
 			}
 			backends = append(backends, b)
 
+		case "completeness":
+			e.Completeness, err = unmarshalCompleteness(d)
+			if err != nil {
+				return
+			}
+			break
+
 		case "concurrent_calls":
 			var arg string
 			arg, err = unmarshalSingleArg(d)
@@ -151,6 +217,41 @@ func unmarshalEndpoint(d *caddyfile.Dispenser) (e Endpoint, err error) {
 			}
 			break
 
+		case "transform":
+			e.Transform, err = unmarshalTransform(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "response_template":
+			e.ResponseTemplate, err = unmarshalResponseTemplate(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "static":
+			e.Static, err = unmarshalStaticData(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "websocket":
+			e.WebSocket, err = unmarshalWebSocket(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "stream":
+			e.Stream, err = unmarshalStream(d)
+			if err != nil {
+				return
+			}
+			break
+
 		default:
 			err = d.Errf("unrecognized subdirective '%s' while parsing endpoint ", d.Val())
 			return
@@ -184,6 +285,13 @@ func unmarshalBackend(d *caddyfile.Dispenser) (b Backend, err error) {
 			}
 			break
 
+		case "name":
+			b.Name, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			break
+
 		case "allow":
 			b.AllowList = d.RemainingArgs()
 			break
@@ -217,6 +325,109 @@ func unmarshalBackend(d *caddyfile.Dispenser) (b Backend, err error) {
 			}
 			b.Mapping = mapping
 			break
+
+		case "transform":
+			b.Transform, err = unmarshalTransform(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "graphql":
+			b.GraphQL, err = unmarshalGraphQL(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "grpc":
+			b.GRPC, err = unmarshalGRPC(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "pubsub":
+			b.PubSub, err = unmarshalPubSub(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "retry":
+			b.Retry, err = unmarshalRetry(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "hedge":
+			b.Hedge, err = unmarshalHedge(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "timeout":
+			b.Timeout, err = unmarshalDuration(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "transport":
+			b.Transport, err = unmarshalTransport(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "variant":
+			var v Variant
+			v, err = unmarshalVariant(d)
+			if err != nil {
+				return
+			}
+			b.Variants = append(b.Variants, v)
+			break
+
+		case "sticky":
+			b.Sticky, err = unmarshalSticky(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "required", "optional":
+			required := d.Val() == "required"
+			if d.NextArg() {
+				err = d.ArgErr()
+				return
+			}
+			b.Required = &required
+			break
+
+		case "fallback":
+			b.Fallback, err = unmarshalFallback(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "shadow":
+			b.Shadow, err = unmarshalShadow(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "variant_header":
+			b.VariantHeader, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			break
+
 		default:
 			err = d.Errf("unrecognized subdirective '%s' while parsing backend ", d.Val())
 			return
@@ -228,6 +439,913 @@ func unmarshalBackend(d *caddyfile.Dispenser) (b Backend, err error) {
 	return
 }
 
+func unmarshalTransform(d *caddyfile.Dispenser) (*Transform, error) {
+	args := d.RemainingArgs()
+	switch len(args) {
+	case 1:
+		return &Transform{Expression: args[0]}, nil
+	case 2:
+		return &Transform{Language: args[0], Expression: args[1]}, nil
+	default:
+		return nil, d.ArgErr()
+	}
+}
+
+func unmarshalResponseTemplate(d *caddyfile.Dispenser) (t *ResponseTemplate, err error) {
+	t = new(ResponseTemplate)
+
+	args := d.RemainingArgs()
+	if len(args) > 1 {
+		return nil, d.ArgErr()
+	}
+	if len(args) == 1 {
+		t.File = args[0]
+	}
+
+	curNesting := d.Nesting()
+	for d.NextBlock(curNesting) {
+		switch d.Val() {
+		case "file":
+			t.File, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "template":
+			t.Template, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "content_type":
+			t.ContentType, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			break
+
+		default:
+			err = d.Errf("unrecognized subdirective '%s' while parsing response_template ", d.Val())
+			return
+		}
+	}
+
+	return
+}
+
+func unmarshalGraphQL(d *caddyfile.Dispenser) (g *GraphQL, err error) {
+	g = new(GraphQL)
+
+	curNesting := d.Nesting()
+	for d.NextBlock(curNesting) {
+		switch d.Val() {
+		case "query":
+			g.Query, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "query_file":
+			g.QueryFile, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "operation_name":
+			g.OperationName, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "variables":
+			g.Variables = make(map[string]interface{})
+			nesting := d.Nesting()
+			for d.NextBlock(nesting) {
+				name := d.Val()
+				var value string
+				value, err = unmarshalSingleArg(d)
+				if err != nil {
+					return
+				}
+				g.Variables[name] = value
+			}
+			break
+
+		default:
+			err = d.Errf("unrecognized subdirective '%s' while parsing graphql ", d.Val())
+			return
+		}
+	}
+
+	return
+}
+
+func unmarshalGRPC(d *caddyfile.Dispenser) (g *GRPC, err error) {
+	g = new(GRPC)
+
+	curNesting := d.Nesting()
+	for d.NextBlock(curNesting) {
+		switch d.Val() {
+		case "protoset":
+			g.Protoset, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "method":
+			g.Method, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			break
+
+		default:
+			err = d.Errf("unrecognized subdirective '%s' while parsing grpc ", d.Val())
+			return
+		}
+	}
+
+	if g.Protoset == "" || g.Method == "" {
+		err = d.Err("grpc backends require both a protoset and a method")
+	}
+
+	return
+}
+
+func unmarshalPubSub(d *caddyfile.Dispenser) (p *PubSub, err error) {
+	p = new(PubSub)
+
+	curNesting := d.Nesting()
+	for d.NextBlock(curNesting) {
+		switch d.Val() {
+		case "topic":
+			p.Topic, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "subscription":
+			p.Subscription, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "metadata":
+			p.Metadata = make(map[string]string)
+			nesting := d.Nesting()
+			for d.NextBlock(nesting) {
+				key := d.Val()
+				var value string
+				value, err = unmarshalSingleArg(d)
+				if err != nil {
+					return
+				}
+				p.Metadata[key] = value
+			}
+			break
+
+		default:
+			err = d.Errf("unrecognized subdirective '%s' while parsing pubsub ", d.Val())
+			return
+		}
+	}
+
+	if p.Topic == "" && p.Subscription == "" {
+		err = d.Err("pubsub backends require a topic or a subscription")
+	}
+
+	return
+}
+
+func unmarshalRetry(d *caddyfile.Dispenser) (r *Retry, err error) {
+	r = new(Retry)
+
+	curNesting := d.Nesting()
+	for d.NextBlock(curNesting) {
+		switch d.Val() {
+		case "max_attempts":
+			var arg string
+			arg, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			r.MaxAttempts, err = strconv.Atoi(arg)
+			if err != nil {
+				err = d.Errf("failed to parse max attempts: %v", err)
+				return
+			}
+			break
+
+		case "backoff":
+			r.Backoff, err = unmarshalDuration(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "max_backoff":
+			r.MaxBackoff, err = unmarshalDuration(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "jitter":
+			var arg string
+			arg, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			r.Jitter, err = strconv.ParseFloat(arg, 64)
+			if err != nil || r.Jitter < 0 || r.Jitter > 1 {
+				err = d.Errf("jitter should be a number between 0 and 1, but got: '%s'", arg)
+				return
+			}
+			break
+
+		case "statuses":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				err = d.ArgErr()
+				return
+			}
+			for _, arg := range args {
+				var status int
+				status, err = strconv.Atoi(arg)
+				if err != nil {
+					err = d.Errf("failed to parse retry status: %v", err)
+					return
+				}
+				r.Statuses = append(r.Statuses, status)
+			}
+			break
+
+		case "non_idempotent":
+			if d.NextArg() {
+				err = d.ArgErr()
+				return
+			}
+			r.NonIdempotent = true
+			break
+
+		default:
+			err = d.Errf("unrecognized subdirective '%s' while parsing retry ", d.Val())
+			return
+		}
+	}
+
+	return
+}
+
+// unmarshalVariant parses "variant <name> weight <weight>", followed by an optional block with the hosts
+// and url pattern of the variant.
+func unmarshalVariant(d *caddyfile.Dispenser) (v Variant, err error) {
+	args := d.RemainingArgs()
+	if len(args) != 3 || args[1] != "weight" {
+		err = d.Errf("variant should be in the format 'variant <name> weight <weight>'")
+		return
+	}
+	v.Name = args[0]
+	v.Weight, err = strconv.Atoi(args[2])
+	if err != nil || v.Weight < 0 {
+		err = d.Errf("bad variant weight '%s'", args[2])
+		return
+	}
+
+	curNesting := d.Nesting()
+	for d.NextBlock(curNesting) {
+		switch d.Val() {
+		case "to":
+			v.Host = append(v.Host, d.RemainingArgs()...)
+			break
+
+		case "url_pattern":
+			v.URLPattern, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			break
+
+		default:
+			err = d.Errf("unrecognized subdirective '%s' while parsing variant ", d.Val())
+			return
+		}
+	}
+
+	return
+}
+
+// unmarshalSticky parses "sticky cookie <name>", "sticky header <name>" or "sticky client_ip".
+func unmarshalSticky(d *caddyfile.Dispenser) (s *Sticky, err error) {
+	args := d.RemainingArgs()
+	if len(args) == 0 {
+		err = d.ArgErr()
+		return
+	}
+
+	s = &Sticky{Source: args[0]}
+	switch {
+	case (s.Source == "cookie" || s.Source == "header") && len(args) == 2:
+		s.Name = args[1]
+	case s.Source == "client_ip" && len(args) == 1:
+	default:
+		err = d.Errf("sticky should be either 'cookie <name>', 'header <name>' or 'client_ip', but got: '%s'", strings.Join(args, " "))
+	}
+
+	return
+}
+
+// unmarshalCompleteness parses "completeness <policy> [<status>]".
+func unmarshalCompleteness(d *caddyfile.Dispenser) (c *Completeness, err error) {
+	args := d.RemainingArgs()
+	if len(args) == 0 || len(args) > 2 {
+		err = d.ArgErr()
+		return
+	}
+
+	c = &Completeness{Policy: args[0]}
+	if c.Policy != "partial" && c.Policy != "require_all" {
+		err = d.Errf("completeness policy should be either 'partial' or 'require_all', but got: '%s'", c.Policy)
+		return
+	}
+	if len(args) == 2 {
+		c.Status, err = strconv.Atoi(args[1])
+		if err != nil {
+			err = d.Errf("bad completeness status '%s'", args[1])
+			return
+		}
+	}
+
+	return
+}
+
+func unmarshalFallback(d *caddyfile.Dispenser) (f *Fallback, err error) {
+	f = new(Fallback)
+
+	curNesting := d.Nesting()
+	for d.NextBlock(curNesting) {
+		switch d.Val() {
+		case "data":
+			var arg string
+			arg, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			err = json.Unmarshal([]byte(arg), &f.Data)
+			if err != nil {
+				err = d.Errf("fallback data should be a JSON object: %v", err)
+				return
+			}
+			break
+
+		case "last_success":
+			var lastSuccess *bool
+			lastSuccess, err = unmarshalBool(d)
+			if err != nil {
+				return
+			}
+			f.LastSuccess = *lastSuccess
+			break
+
+		case "size":
+			var arg string
+			arg, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			f.Size, err = strconv.Atoi(arg)
+			if err != nil || f.Size <= 0 {
+				err = d.Errf("fallback size should be a positive number, but got: '%s'", arg)
+				return
+			}
+			break
+
+		case "key":
+			f.Key, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			break
+
+		default:
+			err = d.Errf("unrecognized subdirective '%s' while parsing fallback ", d.Val())
+			return
+		}
+	}
+
+	return
+}
+
+func unmarshalShadow(d *caddyfile.Dispenser) (s *Shadow, err error) {
+	s = new(Shadow)
+
+	curNesting := d.Nesting()
+	for d.NextBlock(curNesting) {
+		switch d.Val() {
+		case "sample":
+			var arg string
+			arg, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			var sampleRate float64
+			sampleRate, err = strconv.ParseFloat(strings.TrimSuffix(arg, "%"), 64)
+			if err != nil || sampleRate < 0 || sampleRate > 100 {
+				err = d.Errf("shadow sample should be a percentage between 0 and 100, but got: '%s'", arg)
+				return
+			}
+			s.SampleRate = &sampleRate
+			break
+
+		case "timeout":
+			s.Timeout, err = unmarshalDuration(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "log_diff":
+			var logDiff *bool
+			logDiff, err = unmarshalBool(d)
+			if err != nil {
+				return
+			}
+			s.LogDiff = *logDiff
+			break
+
+		case "primary":
+			s.Primary, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			break
+
+		default:
+			err = d.Errf("unrecognized subdirective '%s' while parsing shadow ", d.Val())
+			return
+		}
+	}
+
+	return
+}
+
+func unmarshalHedge(d *caddyfile.Dispenser) (h *Hedge, err error) {
+	h = new(Hedge)
+
+	curNesting := d.Nesting()
+	for d.NextBlock(curNesting) {
+		switch d.Val() {
+		case "delay":
+			var arg string
+			arg, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			// either a fixed duration, or a percentile of the observed latencies such as p95
+			if strings.HasPrefix(arg, "p") {
+				h.Percentile, err = strconv.ParseFloat(arg[1:], 64)
+				if err != nil || h.Percentile <= 0 || h.Percentile > 100 {
+					err = d.Errf("bad hedge delay percentile '%s'", arg)
+					return
+				}
+				break
+			}
+			var dur time.Duration
+			dur, err = caddy.ParseDuration(arg)
+			if err != nil {
+				err = d.Errf("bad duration value %s: %v", arg, err)
+				return
+			}
+			h.Delay = caddy.Duration(dur)
+			break
+
+		case "budget":
+			var arg string
+			arg, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			h.Budget, err = strconv.ParseFloat(strings.TrimSuffix(arg, "%"), 64)
+			if err != nil || h.Budget <= 0 || h.Budget > 100 {
+				err = d.Errf("hedge budget should be a percentage between 0 and 100, but got: '%s'", arg)
+				return
+			}
+			break
+
+		default:
+			err = d.Errf("unrecognized subdirective '%s' while parsing hedge ", d.Val())
+			return
+		}
+	}
+
+	return
+}
+
+func unmarshalRouter(d *caddyfile.Dispenser) (r *Router, err error) {
+	r = new(Router)
+
+	curNesting := d.Nesting()
+	for d.NextBlock(curNesting) {
+		switch d.Val() {
+		case "redirect_trailing_slash":
+			r.RedirectTrailingSlash, err = unmarshalBool(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "redirect_fixed_path":
+			r.RedirectFixedPath, err = unmarshalBool(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "handle_method_not_allowed":
+			r.HandleMethodNotAllowed, err = unmarshalBool(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "handle_options":
+			r.HandleOPTIONS, err = unmarshalBool(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "not_found":
+			r.NotFound, err = unmarshalJSON(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "method_not_allowed":
+			r.MethodNotAllowed, err = unmarshalJSON(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "recover_panics":
+			r.RecoverPanics, err = unmarshalBool(d)
+			if err != nil {
+				return
+			}
+			break
+
+		default:
+			err = d.Errf("unrecognized subdirective '%s' while parsing router ", d.Val())
+			return
+		}
+	}
+
+	return
+}
+
+// unmarshalBool parses an optional boolean argument, such as "true", "off" or "0", a missing one meaning true.
+func unmarshalBool(d *caddyfile.Dispenser) (*bool, error) {
+	b := true
+	if d.NextArg() {
+		switch d.Val() {
+		case "on":
+			b = true
+		case "off":
+			b = false
+		default:
+			var err error
+			b, err = strconv.ParseBool(d.Val())
+			if err != nil {
+				return nil, d.Errf("bad boolean value %s: %v", d.Val(), err)
+			}
+		}
+	}
+	if d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	return &b, nil
+}
+
+func unmarshalJSON(d *caddyfile.Dispenser) (json.RawMessage, error) {
+	arg, err := unmarshalSingleArg(d)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid([]byte(arg)) {
+		return nil, d.Errf("invalid JSON value %s", arg)
+	}
+	return json.RawMessage(arg), nil
+}
+
+func unmarshalTransport(d *caddyfile.Dispenser) (t *Transport, err error) {
+	t = new(Transport)
+
+	curNesting := d.Nesting()
+	for d.NextBlock(curNesting) {
+		switch d.Val() {
+		case "dial_timeout":
+			t.DialTimeout, err = unmarshalDuration(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "response_header_timeout":
+			t.ResponseHeaderTimeout, err = unmarshalDuration(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "tls_handshake_timeout":
+			t.TLSHandshakeTimeout, err = unmarshalDuration(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "tls":
+			if t.TLS == nil {
+				t.TLS = new(reverseproxy.TLSConfig)
+			}
+			break
+
+		case "tls_client_auth":
+			args := d.RemainingArgs()
+			if len(args) != 2 {
+				err = d.ArgErr()
+				return
+			}
+			if t.TLS == nil {
+				t.TLS = new(reverseproxy.TLSConfig)
+			}
+			t.TLS.ClientCertificateFile = args[0]
+			t.TLS.ClientCertificateKeyFile = args[1]
+			break
+
+		case "tls_trust_pool":
+			if !d.NextArg() {
+				err = d.ArgErr()
+				return
+			}
+			source := d.Val()
+			modID := "tls.ca_pool.source." + source
+			var unm caddyfile.Unmarshaler
+			unm, err = caddyfile.UnmarshalModule(d, modID)
+			if err != nil {
+				return
+			}
+			ca, ok := unm.(caddytls.CA)
+			if !ok {
+				err = d.Errf("module %s is not a certificate pool provider", modID)
+				return
+			}
+			if t.TLS == nil {
+				t.TLS = new(reverseproxy.TLSConfig)
+			}
+			t.TLS.CARaw = caddyconfig.JSONModuleObject(ca, "provider", source, nil)
+			break
+
+		case "tls_server_name":
+			if t.TLS == nil {
+				t.TLS = new(reverseproxy.TLSConfig)
+			}
+			t.TLS.ServerName, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "tls_insecure_skip_verify":
+			if t.TLS == nil {
+				t.TLS = new(reverseproxy.TLSConfig)
+			}
+			t.TLS.InsecureSkipVerify = true
+			break
+
+		case "max_idle_conns":
+			var arg string
+			arg, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			t.MaxIdleConns, err = strconv.Atoi(arg)
+			if err != nil {
+				err = d.Errf("failed to parse max idle conns: %v", err)
+				return
+			}
+			break
+
+		case "max_idle_conns_per_host":
+			var arg string
+			arg, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			t.MaxIdleConnsPerHost, err = strconv.Atoi(arg)
+			if err != nil {
+				err = d.Errf("failed to parse max idle conns per host: %v", err)
+				return
+			}
+			break
+
+		case "keep_alive":
+			var arg string
+			arg, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			if arg == "off" {
+				t.KeepAlive = -1
+				break
+			}
+			var dur time.Duration
+			dur, err = caddy.ParseDuration(arg)
+			if err != nil {
+				err = d.Errf("bad duration value %s: %v", arg, err)
+				return
+			}
+			t.KeepAlive = caddy.Duration(dur)
+			break
+
+		case "versions":
+			t.Versions = d.RemainingArgs()
+			if len(t.Versions) == 0 {
+				err = d.ArgErr()
+				return
+			}
+			break
+
+		case "proxy":
+			t.Proxy, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			break
+
+		default:
+			err = d.Errf("unrecognized subdirective '%s' while parsing transport ", d.Val())
+			return
+		}
+	}
+
+	return
+}
+
+func unmarshalStaticData(d *caddyfile.Dispenser) (s *StaticData, err error) {
+	s = new(StaticData)
+
+	curNesting := d.Nesting()
+	for d.NextBlock(curNesting) {
+		switch d.Val() {
+		case "data":
+			var arg string
+			arg, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			err = json.Unmarshal([]byte(arg), &s.Data)
+			if err != nil {
+				err = d.Errf("static data should be a JSON object: %v", err)
+				return
+			}
+			break
+
+		case "strategy":
+			s.Strategy, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			switch s.Strategy {
+			case "always", "success", "errored", "complete", "incomplete":
+			default:
+				err = d.Errf("unrecognized static strategy '%s'", s.Strategy)
+				return
+			}
+			break
+
+		default:
+			err = d.Errf("unrecognized subdirective '%s' while parsing static ", d.Val())
+			return
+		}
+	}
+
+	return
+}
+
+func unmarshalWebSocket(d *caddyfile.Dispenser) (w *WebSocket, err error) {
+	w = new(WebSocket)
+
+	curNesting := d.Nesting()
+	for d.NextBlock(curNesting) {
+		switch d.Val() {
+		case "headers_to_pass":
+			w.HeadersToPass = d.RemainingArgs()
+			if len(w.HeadersToPass) == 0 {
+				err = d.ArgErr()
+				return
+			}
+			break
+
+		case "auth":
+			w.Auth, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "allowed_origins":
+			w.AllowedOrigins = d.RemainingArgs()
+			if len(w.AllowedOrigins) == 0 {
+				err = d.ArgErr()
+				return
+			}
+			break
+
+		case "max_message_size":
+			var arg string
+			arg, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			var size uint64
+			size, err = humanize.ParseBytes(arg)
+			if err != nil {
+				err = d.Errf("bad max message size %s: %v", arg, err)
+				return
+			}
+			w.MaxMessageSize = int64(size)
+			break
+
+		case "max_connections":
+			var arg string
+			arg, err = unmarshalSingleArg(d)
+			if err != nil {
+				return
+			}
+			w.MaxConnections, err = strconv.Atoi(arg)
+			if err != nil {
+				err = d.Errf("failed to parse max connections: %v", err)
+				return
+			}
+			break
+
+		default:
+			err = d.Errf("unrecognized subdirective '%s' while parsing websocket ", d.Val())
+			return
+		}
+	}
+
+	return
+}
+
+func unmarshalStream(d *caddyfile.Dispenser) (s *Stream, err error) {
+	s = new(Stream)
+
+	curNesting := d.Nesting()
+	for d.NextBlock(curNesting) {
+		switch d.Val() {
+		case "headers_to_pass":
+			s.HeadersToPass = d.RemainingArgs()
+			if len(s.HeadersToPass) == 0 {
+				err = d.ArgErr()
+				return
+			}
+			break
+
+		case "heartbeat":
+			s.Heartbeat, err = unmarshalDuration(d)
+			if err != nil {
+				return
+			}
+			break
+
+		case "reconnect":
+			s.Reconnect, err = unmarshalDuration(d)
+			if err != nil {
+				return
+			}
+			break
+
+		default:
+			err = d.Errf("unrecognized subdirective '%s' while parsing stream ", d.Val())
+			return
+		}
+	}
+
+	return
+}
+
 func unmarshalSingleArg(d *caddyfile.Dispenser) (string, error) {
 	args := d.RemainingArgs()
 	if len(args) != 1 {
@@ -1,9 +1,12 @@
 package caddylura
 
 import (
+	"encoding/json"
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp/reverseproxy"
 	"github.com/stretchr/testify/assert"
+	"net/http"
 	"testing"
 	"time"
 )
@@ -111,3 +114,941 @@ lura {
 
 	assert.Equal(t, expected, l)
 }
+
+func TestParseCaddyFileTransform(t *testing.T) {
+	input := `
+lura {
+	endpoint /users/{user} {
+		transform "{id: id, roles: permissions}"
+
+		backend http://mock:8081 {
+			url_pattern /users/{user}
+			transform jsonpath "$.data"
+		}
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	expected := []Endpoint{
+		{
+			URLPattern: "/users/{user}",
+			Transform: &Transform{
+				Expression: "{id: id, roles: permissions}",
+			},
+			Backends: []Backend{
+				{
+					Host:       []string{"http://mock:8081"},
+					URLPattern: "/users/{user}",
+					Transform: &Transform{
+						Language:   "jsonpath",
+						Expression: "$.data",
+					},
+				},
+			},
+		},
+	}
+
+	assert.Equal(t, expected, l.Endpoints)
+}
+
+func TestParseCaddyFileResponseTemplate(t *testing.T) {
+	input := `
+lura {
+	endpoint /legacy/{user} {
+		response_template {
+			template <<TPL
+			<user id="{{ .Data.id }}"/>
+			TPL
+			content_type application/xml
+		}
+
+		backend http://mock:8081 {
+			url_pattern /users/{user}
+		}
+	}
+
+	endpoint /files/{user} {
+		response_template /etc/caddy/user.tpl
+
+		backend http://mock:8081 {
+			url_pattern /users/{user}
+		}
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	if assert.Len(t, l.Endpoints, 2) {
+		assert.Equal(t, &ResponseTemplate{
+			Template:    `<user id="{{ .Data.id }}"/>`,
+			ContentType: "application/xml",
+		}, l.Endpoints[0].ResponseTemplate)
+		assert.Equal(t, &ResponseTemplate{
+			File: "/etc/caddy/user.tpl",
+		}, l.Endpoints[1].ResponseTemplate)
+	}
+}
+
+func TestParseCaddyFileStatic(t *testing.T) {
+	input := `
+lura {
+	endpoint /users/{user} {
+		static {
+			data ` + "`" + `{"promotions": [], "banner": {"enabled": false}}` + "`" + `
+			strategy errored
+		}
+
+		backend http://mock:8081 {
+			url_pattern /users/{user}
+		}
+	}
+
+	endpoint /placeholder {
+		static {
+			data ` + "`" + `{"status": "coming soon"}` + "`" + `
+		}
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	if assert.Len(t, l.Endpoints, 2) {
+		assert.Equal(t, &StaticData{
+			Data: map[string]interface{}{
+				"promotions": []interface{}{},
+				"banner":     map[string]interface{}{"enabled": false},
+			},
+			Strategy: "errored",
+		}, l.Endpoints[0].Static)
+		assert.Equal(t, &StaticData{
+			Data: map[string]interface{}{"status": "coming soon"},
+		}, l.Endpoints[1].Static)
+		assert.Empty(t, l.Endpoints[1].Backends)
+	}
+}
+
+func TestParseCaddyFileStaticInvalid(t *testing.T) {
+	inputs := []string{
+		`lura {
+			endpoint /foo {
+				static {
+					data not-json
+				}
+			}
+		}`,
+		`lura {
+			endpoint /foo {
+				static {
+					strategy sometimes
+				}
+			}
+		}`,
+	}
+
+	for _, input := range inputs {
+		l := new(Lura)
+		assert.Error(t, l.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)))
+	}
+}
+
+func TestParseCaddyFileMockMode(t *testing.T) {
+	input := `
+lura {
+	mock_mode record ./fixtures
+
+	endpoint /users/{user} {
+		backend http://mock:8081 {
+			name users
+			url_pattern /users/{user}
+		}
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	assert.Equal(t, &MockMode{Mode: "record", Fixtures: "./fixtures"}, l.MockMode)
+	assert.Equal(t, "users", l.Endpoints[0].Backends[0].Name)
+}
+
+func TestParseCaddyFileGraphQL(t *testing.T) {
+	input := `
+lura {
+	endpoint /users/{user} {
+		backend http://graphql:4000 {
+			url_pattern /graphql
+			graphql {
+				query "query($id: ID!, $limit: Int) { user(id: $id) { id name } }"
+				operation_name GetUser
+				variables {
+					id {user}
+					limit {http.request.uri.query.limit:int}
+				}
+			}
+		}
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	assert.Equal(t, &GraphQL{
+		Query:         "query($id: ID!, $limit: Int) { user(id: $id) { id name } }",
+		OperationName: "GetUser",
+		Variables: map[string]interface{}{
+			"id":    "{user}",
+			"limit": "{http.request.uri.query.limit:int}",
+		},
+	}, l.Endpoints[0].Backends[0].GraphQL)
+}
+
+func TestParseCaddyFileGRPC(t *testing.T) {
+	input := `
+lura {
+	endpoint /users/{id} {
+		backend http://users:50051 {
+			grpc {
+				protoset users.protoset
+				method users.v1.UserService/GetUser
+			}
+		}
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	assert.Equal(t, &GRPC{
+		Protoset: "users.protoset",
+		Method:   "users.v1.UserService/GetUser",
+	}, l.Endpoints[0].Backends[0].GRPC)
+
+	d = caddyfile.NewTestDispenser(`
+lura {
+	endpoint /users/{id} {
+		backend http://users:50051 {
+			grpc {
+				protoset users.protoset
+			}
+		}
+	}
+}
+`)
+	err = new(Lura).UnmarshalCaddyfile(d)
+	assert.Error(t, err)
+}
+
+func TestParseCaddyFileWebSocket(t *testing.T) {
+	input := `
+lura {
+	endpoint /ws/{room} {
+		websocket {
+			headers_to_pass Authorization X-Tenant-Id
+			auth http://auth:8080/check?room={room}
+			allowed_origins https://app.example.com https://admin.example.com
+			max_message_size 64KiB
+			max_connections 1000
+		}
+		backend http://chat:8080 {
+			url_pattern /rooms/{room}
+		}
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	assert.Equal(t, &WebSocket{
+		HeadersToPass:  []string{"Authorization", "X-Tenant-Id"},
+		Auth:           "http://auth:8080/check?room={room}",
+		AllowedOrigins: []string{"https://app.example.com", "https://admin.example.com"},
+		MaxMessageSize: 65536,
+		MaxConnections: 1000,
+	}, l.Endpoints[0].WebSocket)
+}
+
+func TestParseCaddyFileStream(t *testing.T) {
+	input := `
+lura {
+	endpoint /events/{user} {
+		stream {
+			headers_to_pass Authorization
+			heartbeat 30s
+			reconnect 2s
+		}
+		backend http://notifications:8080 {
+			url_pattern /users/{user}/notifications
+			group notifications
+		}
+		backend http://activity:8080 {
+			url_pattern /users/{user}/activity
+			group activity
+		}
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	assert.Equal(t, &Stream{
+		HeadersToPass: []string{"Authorization"},
+		Heartbeat:     caddy.Duration(30 * time.Second),
+		Reconnect:     caddy.Duration(2 * time.Second),
+	}, l.Endpoints[0].Stream)
+	assert.Len(t, l.Endpoints[0].Backends, 2)
+}
+
+func TestParseCaddyFilePubSub(t *testing.T) {
+	input := `
+lura {
+	endpoint /orders/{id} {
+		method POST
+		backend {
+			pubsub {
+				topic nats://orders
+				subscription nats://orders
+				metadata {
+					tenant {http.request.header.X-Tenant-Id}
+					order {id}
+				}
+			}
+		}
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	assert.Equal(t, &PubSub{
+		Topic:        "nats://orders",
+		Subscription: "nats://orders",
+		Metadata: map[string]string{
+			"tenant": "{http.request.header.X-Tenant-Id}",
+			"order":  "{id}",
+		},
+	}, l.Endpoints[0].Backends[0].PubSub)
+
+	d = caddyfile.NewTestDispenser(`
+lura {
+	endpoint /orders {
+		backend {
+			pubsub {
+				metadata {
+					tenant acme
+				}
+			}
+		}
+	}
+}
+`)
+	err = new(Lura).UnmarshalCaddyfile(d)
+	assert.Error(t, err)
+}
+
+func TestPubSubOptions(t *testing.T) {
+	p := &PubSub{Topic: "nats://orders", Subscription: "nats://orders"}
+
+	opts, err := p.options(http.MethodGet)
+	if assert.NoError(t, err) {
+		assert.True(t, opts.Pull, "GET endpoints pull")
+	}
+
+	opts, err = p.options(http.MethodPost)
+	if assert.NoError(t, err) {
+		assert.False(t, opts.Pull, "other endpoints publish")
+	}
+
+	_, err = (&PubSub{Topic: "nats://orders"}).options(http.MethodGet)
+	assert.Error(t, err)
+	_, err = (&PubSub{Subscription: "nats://orders"}).options(http.MethodPost)
+	assert.Error(t, err)
+}
+
+func TestParseCaddyFileRetry(t *testing.T) {
+	input := `
+lura {
+	endpoint /users/{id} {
+		backend http://users:8080 {
+			url_pattern /users/{id}
+			retry {
+				max_attempts 4
+				backoff 50ms
+				max_backoff 1s
+				jitter 0.5
+				statuses 429 502 503
+				non_idempotent
+			}
+		}
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	assert.Equal(t, &Retry{
+		MaxAttempts:   4,
+		Backoff:       caddy.Duration(50 * time.Millisecond),
+		MaxBackoff:    caddy.Duration(time.Second),
+		Jitter:        0.5,
+		Statuses:      []int{429, 502, 503},
+		NonIdempotent: true,
+	}, l.Endpoints[0].Backends[0].Retry)
+}
+
+func TestParseCaddyFileHedge(t *testing.T) {
+	input := `
+lura {
+	endpoint /users/{id} {
+		backend http://users-1:8080 http://users-2:8080 {
+			url_pattern /users/{id}
+			hedge {
+				delay p99
+				budget 5%
+			}
+		}
+		backend http://roles-1:8080 http://roles-2:8080 {
+			url_pattern /roles/{id}
+			hedge {
+				delay 50ms
+			}
+		}
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	assert.Equal(t, &Hedge{Percentile: 99, Budget: 5}, l.Endpoints[0].Backends[0].Hedge)
+	assert.Equal(t, &Hedge{Delay: caddy.Duration(50 * time.Millisecond)}, l.Endpoints[0].Backends[1].Hedge)
+}
+
+func TestParseCaddyFileVariants(t *testing.T) {
+	input := `
+lura {
+	endpoint /users/{id} {
+		backend http://users:8080 {
+			name users
+			url_pattern /users/{id}
+			variant v1 weight 90
+			variant v2 weight 10 {
+				to http://users-v2:8080
+				url_pattern /v2/users/{id}
+			}
+			sticky cookie session_id
+			variant_header X-Users-Variant
+		}
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	b := l.Endpoints[0].Backends[0]
+	assert.Equal(t, []Variant{
+		{Name: "v1", Weight: 90},
+		{Name: "v2", Weight: 10, Host: []string{"http://users-v2:8080"}, URLPattern: "/v2/users/{id}"},
+	}, b.Variants)
+	assert.Equal(t, &Sticky{Source: "cookie", Name: "session_id"}, b.Sticky)
+	assert.Equal(t, "X-Users-Variant", b.VariantHeader)
+
+	split, hosts, err := b.split(newLocalUpstreams(), "")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"http://users:8080", "http://users-v2:8080"}, hosts)
+		assert.Equal(t, "{http.request.cookie.session_id}", split.Key)
+		assert.Equal(t, "/users/{id}", split.Variants[0].URLPattern)
+		assert.Equal(t, "/v2/users/{id}", split.Variants[1].URLPattern)
+	}
+
+	for _, bad := range []string{
+		"variant v1",
+		"variant v1 weight heavy",
+		"sticky cookie",
+		"sticky client_ip X-Real-Ip",
+		"sticky session",
+	} {
+		d := caddyfile.NewTestDispenser("lura {\n endpoint /users {\n backend http://users:8080 {\n " + bad + "\n }\n }\n}")
+		assert.Error(t, new(Lura).UnmarshalCaddyfile(d), bad)
+	}
+
+	_, _, err = Backend{Variants: []Variant{{Name: "v1"}, {Name: "v2"}}, Host: []string{"http://users:8080"}}.split(newLocalUpstreams(), "")
+	assert.Error(t, err, "the weights cannot add up to zero")
+	_, _, err = Backend{Variants: []Variant{{Name: "v1", Weight: 1}}}.split(newLocalUpstreams(), "")
+	assert.Error(t, err, "variants need hosts")
+
+	split, hosts, err = Backend{Variants: []Variant{{Name: "v1", Weight: 1, Host: []string{"127.0.0.1:8080"}}, {Name: "v2", Weight: 1}}, Host: []string{"users"}}.split(newLocalUpstreams(), "")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"http://127.0.0.1:8080", "http://users"}, hosts, "hosts without a scheme are reached over http")
+		assert.Equal(t, []string{"http://127.0.0.1:8080"}, split.Variants[0].Host)
+		assert.Equal(t, []string{"http://users"}, split.Variants[1].Host)
+	}
+	_, _, err = Backend{Variants: []Variant{{Name: "v1", Weight: 1, Host: []string{"users:8080:9090"}}}}.split(newLocalUpstreams(), "")
+	assert.Error(t, err, "variant hosts are validated")
+}
+
+func TestParseCaddyFileShadow(t *testing.T) {
+	input := `
+lura {
+	endpoint /users/{id} {
+		backend http://users:8080 {
+			url_pattern /users/{id}
+		}
+		backend http://users-next:8080 {
+			url_pattern /users/{id}
+			shadow {
+				sample 5%
+				timeout 3s
+				log_diff
+				primary users:8080
+			}
+		}
+		backend http://users-legacy:8080 {
+			url_pattern /users/{id}
+			shadow
+		}
+		backend http://users-paused:8080 {
+			url_pattern /users/{id}
+			shadow {
+				sample 0
+			}
+		}
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	backends := l.Endpoints[0].Backends
+	assert.Nil(t, backends[0].Shadow)
+	sampleRate := 5.0
+	assert.Equal(t, &Shadow{SampleRate: &sampleRate, Timeout: caddy.Duration(3 * time.Second), LogDiff: true, Primary: "users:8080"}, backends[1].Shadow)
+	assert.Equal(t, &Shadow{}, backends[2].Shadow)
+	assert.Equal(t, 100.0, backends[2].Shadow.options("").SampleRate, "all the requests are copied by default")
+	assert.Equal(t, 0.0, backends[3].Shadow.options("").SampleRate, "a zero sample rate pauses the copies")
+	assert.False(t, l.Endpoints[0].shadowsOnly())
+
+	d = caddyfile.NewTestDispenser("lura {\n endpoint /users {\n backend http://users:8080 {\n shadow {\n sample 150%\n }\n }\n }\n}")
+	assert.Error(t, new(Lura).UnmarshalCaddyfile(d))
+}
+
+func TestEndpointShadowPrimary(t *testing.T) {
+	users := Backend{Host: []string{"http://users:8080"}}
+	roles := Backend{Host: []string{"http://roles:8080"}}
+	next := Backend{Host: []string{"http://users-next:8080"}, Shadow: &Shadow{LogDiff: true}}
+
+	primary, err := Endpoint{Backends: []Backend{users, next}}.shadowPrimary(next.Shadow)
+	assert.NoError(t, err)
+	assert.Equal(t, "users:8080", primary, "the only primary backend is assumed")
+
+	_, err = Endpoint{Backends: []Backend{users, roles, next}}.shadowPrimary(next.Shadow)
+	assert.Error(t, err, "several backends require a primary")
+
+	primary, err = Endpoint{Backends: []Backend{users, roles, next}}.shadowPrimary(&Shadow{LogDiff: true, Primary: "roles:8080"})
+	assert.NoError(t, err)
+	assert.Equal(t, "roles:8080", primary)
+
+	_, err = Endpoint{Backends: []Backend{users, next}}.shadowPrimary(&Shadow{LogDiff: true, Primary: "orders"})
+	assert.Error(t, err)
+
+	primary, err = Endpoint{Backends: []Backend{users, roles}}.shadowPrimary(&Shadow{})
+	assert.NoError(t, err)
+	assert.Empty(t, primary, "responses are not compared without log_diff")
+}
+
+func TestParseCaddyFileCompleteness(t *testing.T) {
+	input := `
+lura {
+	endpoint /users/{id} {
+		completeness require_all 503
+		backend http://users:8080 {
+			url_pattern /users/{id}
+		}
+		backend http://avatars:8080 {
+			url_pattern /avatars/{id}
+			optional
+		}
+	}
+	endpoint /orders/{id} {
+		backend http://orders:8080 {
+			url_pattern /orders/{id}
+			required
+		}
+		backend http://recommendations:8080 {
+			url_pattern /recommendations/{id}
+		}
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	users := l.Endpoints[0]
+	assert.Equal(t, &Completeness{Policy: "require_all", Status: 503}, users.Completeness)
+	assert.True(t, users.Backends[0].required(users.Completeness))
+	assert.False(t, users.Backends[1].required(users.Completeness))
+
+	orders := l.Endpoints[1]
+	assert.Nil(t, orders.Completeness)
+	assert.True(t, orders.Backends[0].required(orders.Completeness))
+	assert.False(t, orders.Backends[1].required(orders.Completeness))
+
+	for _, bad := range []string{"completeness", "completeness all", "completeness require_all fail"} {
+		d := caddyfile.NewTestDispenser("lura {\n endpoint /users {\n " + bad + "\n }\n}")
+		assert.Error(t, new(Lura).UnmarshalCaddyfile(d), bad)
+	}
+}
+
+func TestParseCaddyFileFallback(t *testing.T) {
+	input := `
+lura {
+	endpoint /users/{id} {
+		backend http://users:8080 {
+			url_pattern /users/{id}
+		}
+		backend http://avatars:8080 {
+			url_pattern /avatars/{id}
+			group avatar
+			fallback {
+				data ` + "`" + `{"url": "/default.png"}` + "`" + `
+			}
+		}
+		backend http://roles:8080 {
+			url_pattern /roles/{id}
+			fallback {
+				last_success
+				size 500
+				key {http.request.header.X-Tenant-Id}/{id}
+			}
+		}
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	backends := l.Endpoints[0].Backends
+	assert.Nil(t, backends[0].Fallback)
+	assert.Equal(t, &Fallback{Data: map[string]interface{}{"url": "/default.png"}}, backends[1].Fallback)
+	assert.Equal(t, map[string]interface{}{"avatar": map[string]interface{}{"url": "/default.png"}}, backends[1].Fallback.options(backends[1].Group).Data)
+	assert.Equal(t, &Fallback{LastSuccess: true, Size: 500, Key: "{http.request.header.X-Tenant-Id}/{id}"}, backends[2].Fallback)
+
+	for _, bad := range []string{"data not-json", "size none", "size 0", "cache"} {
+		d := caddyfile.NewTestDispenser("lura {\n endpoint /users {\n backend http://users:8080 {\n fallback {\n " + bad + "\n }\n }\n }\n}")
+		assert.Error(t, new(Lura).UnmarshalCaddyfile(d), bad)
+	}
+}
+
+func TestParseCaddyFileQueryStrings(t *testing.T) {
+	input := `
+lura {
+	endpoint /users {
+		query_strings page limit
+		backend http://users:8080 {
+			url_pattern /users
+		}
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	assert.Equal(t, []string{"page", "limit"}, l.Endpoints[0].QueryStrings)
+
+	d = caddyfile.NewTestDispenser("lura {\n endpoint /users {\n query_strings\n }\n}")
+	assert.Error(t, new(Lura).UnmarshalCaddyfile(d))
+}
+
+func TestParseCaddyFileBackendTimeout(t *testing.T) {
+	input := `
+lura {
+	endpoint /users/{id} {
+		timeout 3s
+		backend http://users:8080 {
+			url_pattern /users/{id}
+			timeout 500ms
+			transport {
+				dial_timeout 100ms
+				response_header_timeout 400ms
+				tls_handshake_timeout 200ms
+			}
+		}
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	b := l.Endpoints[0].Backends[0]
+	assert.Equal(t, caddy.Duration(500*time.Millisecond), b.Timeout)
+	assert.Equal(t, &Transport{
+		DialTimeout:           caddy.Duration(100 * time.Millisecond),
+		ResponseHeaderTimeout: caddy.Duration(400 * time.Millisecond),
+		TLSHandshakeTimeout:   caddy.Duration(200 * time.Millisecond),
+	}, b.Transport)
+}
+
+func TestParseCaddyFileTransport(t *testing.T) {
+	input := `
+lura {
+	transport {
+		max_idle_conns 50
+		max_idle_conns_per_host 10
+		keep_alive off
+		proxy http://proxy.internal:3128
+	}
+	endpoint /users/{id} {
+		backend https://users:8443 {
+			url_pattern /users/{id}
+			transport {
+				tls_client_auth /certs/client.pem /certs/client-key.pem
+				tls_trust_pool file /certs/ca.pem
+				tls_server_name users.internal
+				versions 1.1 2
+			}
+		}
+		backend http://roles:8080 {
+			url_pattern /roles/{id}
+			transport {
+				versions h2c
+				keep_alive 1m
+			}
+		}
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	assert.Equal(t, &Transport{
+		MaxIdleConns:        50,
+		MaxIdleConnsPerHost: 10,
+		KeepAlive:           -1,
+		Proxy:               "http://proxy.internal:3128",
+	}, l.Transport)
+
+	assert.Equal(t, &Transport{
+		TLS: &reverseproxy.TLSConfig{
+			CARaw:                    json.RawMessage(`{"pem_files":["/certs/ca.pem"],"provider":"file"}`),
+			ClientCertificateFile:    "/certs/client.pem",
+			ClientCertificateKeyFile: "/certs/client-key.pem",
+			ServerName:               "users.internal",
+		},
+		Versions: []string{"1.1", "2"},
+	}, l.Endpoints[0].Backends[0].Transport)
+
+	assert.Equal(t, &Transport{
+		Versions:  []string{"h2c"},
+		KeepAlive: caddy.Duration(time.Minute),
+	}, l.Endpoints[0].Backends[1].Transport)
+}
+
+func TestTransportUnsupported(t *testing.T) {
+	transport := &Transport{
+		DialTimeout: caddy.Duration(time.Second),
+		TLS:         &reverseproxy.TLSConfig{ServerName: "users.internal"},
+		Versions:    []string{"2"},
+		Proxy:       "http://proxy.internal:3128",
+	}
+
+	assert.Equal(t, []string{"dial_timeout", "versions", "proxy"}, transport.unsupported("tls"))
+	assert.Equal(t, []string{"versions"}, transport.unsupported("tls", "dial_timeout", "keep_alive", "proxy"))
+	assert.Empty(t, (*Transport)(nil).unsupported())
+}
+
+func TestParseCaddyFileMethods(t *testing.T) {
+	input := `
+lura {
+	endpoint /users/{id} {
+		methods GET PUT OPTIONS
+		backend http://users:8080 {
+			url_pattern /users/{id}
+		}
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	assert.Equal(t, []string{"GET", "PUT", "OPTIONS"}, l.Endpoints[0].Methods)
+
+	endpoints := l.endpointsByMethod()
+	if assert.Len(t, endpoints, 3) {
+		assert.Equal(t, "GET", endpoints[0].Method)
+		assert.Equal(t, "PUT", endpoints[1].Method)
+		assert.Equal(t, "OPTIONS", endpoints[2].Method)
+	}
+
+	assert.Equal(t, []string{"POST", "GET"}, Endpoint{Method: "post", Methods: []string{"POST", "get"}}.methods())
+	assert.Equal(t, []string{"GET"}, Endpoint{}.methods())
+}
+
+func TestParseCaddyFileRouter(t *testing.T) {
+	input := `
+lura {
+	router {
+		redirect_trailing_slash false
+		redirect_fixed_path off
+		handle_options
+		not_found ` + "`" + `{"error": "not found"}` + "`" + `
+		method_not_allowed ` + "`" + `{"error": "method not allowed"}` + "`" + `
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	disabled, enabled := false, true
+	assert.Equal(t, &Router{
+		RedirectTrailingSlash: &disabled,
+		RedirectFixedPath:     &disabled,
+		HandleOPTIONS:         &enabled,
+		NotFound:              json.RawMessage(`{"error": "not found"}`),
+		MethodNotAllowed:      json.RawMessage(`{"error": "method not allowed"}`),
+	}, l.Router)
+
+	opts := l.Router.options()
+	assert.False(t, opts.RedirectTrailingSlash)
+	assert.True(t, opts.HandleMethodNotAllowed, "unspecified settings keep their default")
+	assert.True(t, opts.RecoverPanics)
+}
+
+func TestParseCaddyFileMatch(t *testing.T) {
+	input := `
+lura {
+	endpoint /users/{id} {
+		match {
+			header Accept-Version v2
+		}
+		match {
+			query version=2
+		}
+		backend http://users-v2:8080 {
+			url_pattern /users/{id}
+		}
+	}
+	endpoint /users/{id} {
+		backend http://users:8080 {
+			url_pattern /users/{id}
+		}
+	}
+}
+`
+	d := caddyfile.NewTestDispenser(input)
+
+	l := new(Lura)
+	err := l.UnmarshalCaddyfile(d)
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+
+	if assert.Len(t, l.Endpoints, 2) {
+		if assert.Len(t, l.Endpoints[0].MatcherSetsRaw, 2) {
+			assert.JSONEq(t, `{"Accept-Version": ["v2"]}`, string(l.Endpoints[0].MatcherSetsRaw[0]["header"]))
+			assert.JSONEq(t, `{"version": ["2"]}`, string(l.Endpoints[0].MatcherSetsRaw[1]["query"]))
+		}
+		assert.Empty(t, l.Endpoints[1].MatcherSetsRaw)
+	}
+}
@@ -64,6 +64,22 @@
 //   /files/templates/article.html       match: filepath="/templates/article.html"
 //   /files                              no match, but the router would redirect
 //
+// Static segments, named parameters and catch-all parameters may share the same
+// position. Static segments take precedence over named parameters, and named
+// parameters over catch-all parameters. If the preferred route does not match
+// the rest of the path, the next one is tried:
+//  Paths: /users/me, /users/:id/posts, /users/*rest
+//
+//  Requests:
+//   /users/me                           match: /users/me
+//   /users/me/posts                     match: /users/:id/posts, id="me"
+//   /users/me/avatar.png                match: /users/*rest, rest="/me/avatar.png"
+//
+// Named parameters may be constrained by a regular expression when registered
+// with HandleConstrained: they only match the segments matched by it, otherwise
+// the next route is tried. Constrained parameters take precedence over
+// unconstrained ones, and among them the first registered is tried first.
+//
 // The value of parameters is saved as a slice of the Param struct, consisting
 // each of a key and a value. The slice is passed to the Handle func as a third
 // parameter.
@@ -80,6 +96,7 @@ import (
 	"context"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"net/http"
+	"regexp"
 	"strings"
 	"sync"
 )
@@ -292,6 +309,16 @@ func (r *Router) DELETE(path string, handle Handle) {
 // frequently used, non-standardized or custom methods (e.g. for internal
 // communication with a proxy).
 func (r *Router) Handle(method, path string, handle Handle) {
+	r.HandleConstrained(method, path, nil, handle)
+}
+
+// HandleConstrained registers a new request handle with the given path and
+// method, like Handle, where the named parameters listed in constraints only
+// match the values matched by their regular expression. The expressions are
+// not anchored by the router.
+//
+// The same path may be registered several times with different constraints.
+func (r *Router) HandleConstrained(method, path string, constraints map[string]*regexp.Regexp, handle Handle) {
 	varsCount := uint16(0)
 
 	if method == "" {
@@ -321,7 +348,7 @@ func (r *Router) Handle(method, path string, handle Handle) {
 		r.globalAllowed = r.allowed("*", "")
 	}
 
-	root.addRoute(path, handle)
+	root.addConstrainedRoute(path, constraints, handle)
 
 	// Update maxParams
 	if paramsCount := countParams(path); paramsCount+varsCount > r.maxParams {
@@ -5,6 +5,7 @@
 package httprouter
 
 import (
+	"regexp"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -72,13 +73,66 @@ const (
 )
 
 type node struct {
-	path      string
-	indices   string
-	wildChild bool
-	nType     nodeType
-	priority  uint32
-	children  []*node
-	handle    Handle
+	path       string
+	indices    string
+	nType      nodeType
+	priority   uint32
+	children   []*node
+	handle     Handle
+	constraint *regexp.Regexp
+}
+
+// The children of a node are kept in two groups: first the static children,
+// indexed by the first byte of their path in indices, then the wildcard
+// children: the constrained params in the order they were added, at most one
+// unconstrained param and at most one catch-all. Lookups try them in this
+// order, so static segments take precedence over parameters, constrained
+// parameters over unconstrained ones and parameters over catch-alls.
+
+// Returns the wildcard (param or catch-all) children of the node
+func (n *node) wildChildren() []*node {
+	return n.children[len(n.indices):]
+}
+
+// Inserts a static child for the index char just appended to the indices,
+// ahead of the wildcard children
+func (n *node) addStaticChild(child *node) {
+	pos := len(n.indices) - 1
+	n.children = append(n.children, nil)
+	copy(n.children[pos+1:], n.children[pos:])
+	n.children[pos] = child
+}
+
+// Adds a wildcard child after the ones taking precedence over it
+func (n *node) addWildChild(child *node) {
+	pos := len(n.children)
+	for pos > len(n.indices) && n.children[pos-1].wildRank() > child.wildRank() {
+		pos--
+	}
+
+	n.children = append(n.children, nil)
+	copy(n.children[pos+1:], n.children[pos:])
+	n.children[pos] = child
+}
+
+// Returns the precedence of a wildcard node, lowest first
+func (n *node) wildRank() int {
+	switch {
+	case n.nType == catchAll:
+		return 2
+	case n.constraint == nil:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Reports whether the param node n takes values constrained by c
+func (n *node) hasConstraint(c *regexp.Regexp) bool {
+	if n.constraint == nil || c == nil {
+		return n.constraint == c
+	}
+	return n.constraint.String() == c.String()
 }
 
 // Increments priority of the given child and reorders if necessary
@@ -107,12 +161,20 @@ func (n *node) incrementChildPrio(pos int) int {
 // addRoute adds a node with the given handle to the path.
 // Not concurrency-safe!
 func (n *node) addRoute(path string, handle Handle) {
+	n.addConstrainedRoute(path, nil, handle)
+}
+
+// addConstrainedRoute adds a node with the given handle to the path, whose
+// params only match the values matched by their regular expression in
+// constraints, if any.
+// Not concurrency-safe!
+func (n *node) addConstrainedRoute(path string, constraints map[string]*regexp.Regexp, handle Handle) {
 	fullPath := path
 	n.priority++
 
 	// Empty tree
 	if n.path == "" && n.indices == "" {
-		n.insertChild(path, fullPath, handle)
+		n.insertChild(path, fullPath, constraints, handle)
 		n.nType = root
 		return
 	}
@@ -127,13 +189,12 @@ walk:
 		// Split edge
 		if i < len(n.path) {
 			child := node{
-				path:      n.path[i:],
-				wildChild: n.wildChild,
-				nType:     static,
-				indices:   n.indices,
-				children:  n.children,
-				handle:    n.handle,
-				priority:  n.priority - 1,
+				path:     n.path[i:],
+				nType:    static,
+				indices:  n.indices,
+				children: n.children,
+				handle:   n.handle,
+				priority: n.priority - 1,
 			}
 
 			n.children = []*node{&child}
@@ -141,46 +202,53 @@ walk:
 			n.indices = string([]byte{n.path[i]})
 			n.path = path[:i]
 			n.handle = nil
-			n.wildChild = false
 		}
 
 		// Make new node a child of this node
 		if i < len(path) {
 			path = path[i:]
+			idxc := path[0]
 
-			if n.wildChild {
-				n = n.children[0]
-				n.priority++
+			if idxc == ':' || idxc == '*' {
+				// Walk into the existing wildcard of the same type and
+				// constraint, which must have the same name
+				wildcard, _, valid := findWildcard(path)
+				var constraint *regexp.Regexp
+				if idxc == ':' && len(wildcard) > 1 {
+					constraint = constraints[wildcard[1:]]
+				}
+				for _, child := range n.wildChildren() {
+					if !valid || (child.nType == catchAll) != (idxc == '*') ||
+						(child.nType == param && !child.hasConstraint(constraint)) {
+						continue
+					}
+
+					if child.path != wildcard {
+						// Wildcard conflict
+						pathSeg := path
+						if child.nType != catchAll {
+							pathSeg = strings.SplitN(pathSeg, "/", 2)[0]
+						}
+						prefix := fullPath[:strings.Index(fullPath, pathSeg)] + child.path
+						panic("'" + pathSeg +
+							"' in new path '" + fullPath +
+							"' conflicts with existing wildcard '" + child.path +
+							"' in existing prefix '" + prefix +
+							"'")
+					}
 
-				// Check if the wildcard matches
-				if len(path) >= len(n.path) && n.path == path[:len(n.path)] &&
 					// Adding a child to a catchAll is not possible
-					n.nType != catchAll &&
-					// Check for longer wildcard, e.g. :name and :names
-					(len(n.path) >= len(path) || path[len(n.path)] == '/') {
-					continue walk
-				} else {
-					// Wildcard conflict
-					pathSeg := path
-					if n.nType != catchAll {
-						pathSeg = strings.SplitN(pathSeg, "/", 2)[0]
+					if child.nType == catchAll && len(wildcard) < len(path) {
+						panic("catch-all routes are only allowed at the end of the path in path '" + fullPath + "'")
 					}
-					prefix := fullPath[:strings.Index(fullPath, pathSeg)] + n.path
-					panic("'" + pathSeg +
-						"' in new path '" + fullPath +
-						"' conflicts with existing wildcard '" + n.path +
-						"' in existing prefix '" + prefix +
-						"'")
-				}
-			}
 
-			idxc := path[0]
+					child.priority++
+					n = child
+					continue walk
+				}
 
-			// '/' after param
-			if n.nType == param && idxc == '/' && len(n.children) == 1 {
-				n = n.children[0]
-				n.priority++
-				continue walk
+				n.insertChild(path, fullPath, constraints, handle)
+				return
 			}
 
 			// Check if a child with the next path byte exists
@@ -193,15 +261,13 @@ walk:
 			}
 
 			// Otherwise insert it
-			if idxc != ':' && idxc != '*' {
-				// []byte for proper unicode char conversion, see #65
-				n.indices += string([]byte{idxc})
-				child := &node{}
-				n.children = append(n.children, child)
-				n.incrementChildPrio(len(n.indices) - 1)
-				n = child
-			}
-			n.insertChild(path, fullPath, handle)
+			// []byte for proper unicode char conversion, see #65
+			n.indices += string([]byte{idxc})
+			child := &node{}
+			n.addStaticChild(child)
+			n.incrementChildPrio(len(n.indices) - 1)
+			n = child
+			n.insertChild(path, fullPath, constraints, handle)
 			return
 		}
 
@@ -214,7 +280,9 @@ walk:
 	}
 }
 
-func (n *node) insertChild(path, fullPath string, handle Handle) {
+// insertChild inserts path below n. If path starts with a wildcard, it is
+// added as a new wildcard child of n, otherwise n must be a new, empty node.
+func (n *node) insertChild(path, fullPath string, constraints map[string]*regexp.Regexp, handle Handle) {
 	for {
 		// Find prefix until first wildcard
 		wildcard, i, valid := findWildcard(path)
@@ -233,13 +301,6 @@ func (n *node) insertChild(path, fullPath string, handle Handle) {
 			panic("wildcards must be named with a non-empty name in path '" + fullPath + "'")
 		}
 
-		// Check if this node has existing children which would be
-		// unreachable if we insert the wildcard here
-		if len(n.children) > 0 {
-			panic("wildcard segment '" + wildcard +
-				"' conflicts with existing children in path '" + fullPath + "'")
-		}
-
 		// param
 		if wildcard[0] == ':' {
 			if i > 0 {
@@ -248,12 +309,12 @@ func (n *node) insertChild(path, fullPath string, handle Handle) {
 				path = path[i:]
 			}
 
-			n.wildChild = true
 			child := &node{
-				nType: param,
-				path:  wildcard,
+				nType:      param,
+				path:       wildcard,
+				constraint: constraints[wildcard[1:]],
 			}
-			n.children = []*node{child}
+			n.addWildChild(child)
 			n = child
 			n.priority++
 
@@ -264,6 +325,7 @@ func (n *node) insertChild(path, fullPath string, handle Handle) {
 				child := &node{
 					priority: 1,
 				}
+				n.indices = "/"
 				n.children = []*node{child}
 				n = child
 				continue
@@ -279,37 +341,22 @@ func (n *node) insertChild(path, fullPath string, handle Handle) {
 			panic("catch-all routes are only allowed at the end of the path in path '" + fullPath + "'")
 		}
 
-		if len(n.path) > 0 && n.path[len(n.path)-1] == '/' {
-			panic("catch-all conflicts with existing handle for the path segment root in path '" + fullPath + "'")
-		}
-
-		// Currently fixed width 1 for '/'
-		i--
-		if path[i] != '/' {
+		// The node holding the catch-all ends with the '/' before it, which
+		// is part of the catch-all value
+		if pos := len(fullPath) - len(path) + i; fullPath[pos-1] != '/' {
 			panic("no / before catch-all in path '" + fullPath + "'")
 		}
 
-		n.path = path[:i]
-
-		// First node: catchAll node with empty path
-		child := &node{
-			wildChild: true,
-			nType:     catchAll,
+		if i > 0 {
+			n.path = path[:i]
 		}
-		n.children = []*node{child}
-		n.indices = string('/')
-		n = child
-		n.priority++
-
-		// Second node: node holding the variable
-		child = &node{
-			path:     path[i:],
+
+		n.addWildChild(&node{
+			path:     wildcard,
 			nType:    catchAll,
 			handle:   handle,
 			priority: 1,
-		}
-		n.children = []*node{child}
-
+		})
 		return
 	}
 
@@ -320,146 +367,142 @@ func (n *node) insertChild(path, fullPath string, handle Handle) {
 
 // Returns the handle registered with the given path (key). The values of
 // wildcards are saved to a map.
+// At every position static children are tried first, then the params whose
+// constraint matches the segment and then the catch-all; if a branch leads to
+// no handle, the lookup backtracks and tries the next one, so the first route
+// in this order wins.
 // If no handle can be found, a TSR (trailing slash redirect) recommendation is
 // made if a handle exists with an extra (without the) trailing slash for the
 // given path.
 func (n *node) getValue(path string, params func() *Params) (handle Handle, ps *Params, tsr bool) {
-walk: // Outer loop for walking the tree
-	for {
-		prefix := n.path
-		if len(path) > len(prefix) {
-			if path[:len(prefix)] == prefix {
-				path = path[len(prefix):]
-
-				// If this node does not have a wildcard (param or catchAll)
-				// child, we can just look up the next child node and continue
-				// to walk down the tree
-				if !n.wildChild {
-					idxc := path[0]
-					for i, c := range []byte(n.indices) {
-						if c == idxc {
-							n = n.children[i]
-							continue walk
-						}
-					}
-
-					// Nothing found.
-					// We can recommend to redirect to the same URL without a
-					// trailing slash if a leaf exists for that path.
-					tsr = (path == "/" && n.handle != nil)
-					return
-				}
-
-				// Handle wildcard child
-				n = n.children[0]
-				switch n.nType {
-				case param:
-					// Find param end (either '/' or path end)
-					end := 0
-					for end < len(path) && path[end] != '/' {
-						end++
-					}
-
-					// Save param value
-					if params != nil {
-						if ps == nil {
-							ps = params()
-						}
-						// Expand slice within preallocated capacity
-						i := len(*ps)
-						*ps = (*ps)[:i+1]
-						(*ps)[i] = Param{
-							Key:   n.path[1:],
-							Value: path[:end],
-						}
-					}
+	if handle, ps = n.match(path, params); handle != nil {
+		return
+	}
 
-					// We need to go deeper!
-					if end < len(path) {
-						if len(n.children) > 0 {
-							path = path[end:]
-							n = n.children[0]
-							continue walk
-						}
+	// No handle found. Check if a handle for this path with an extra (without
+	// the) trailing slash exists for TSR recommendation
+	if len(path) > 1 && path[len(path)-1] == '/' {
+		h, _ := n.match(path[:len(path)-1], nil)
+		tsr = h != nil
+	} else if path != "" {
+		h, _ := n.match(path+"/", nil)
+		tsr = h != nil
+	}
+	return
+}
 
-						// ... but we can't
-						tsr = (len(path) == end+1)
-						return
-					}
+// Looks up the handle of the given path, saving the values of wildcards to
+// the Params returned by params.
+func (n *node) match(path string, params func() *Params) (Handle, *Params) {
+	if !strings.HasPrefix(path, n.path) {
+		return nil, nil
+	}
+	return n.matchChildren(path[len(n.path):], path, params, nil)
+}
 
-					if handle = n.handle; handle != nil {
-						return
-					} else if len(n.children) == 1 {
-						// No handle found. Check if a handle for this path + a
-						// trailing slash exists for TSR recommendation
-						n = n.children[0]
-						tsr = (n.path == "/" && n.handle != nil) || (n.path == "" && n.indices == "/")
-					}
+// Recursive lookup function used by n.match. path is what is left of the
+// looked up path after the one of n, fullPath the whole looked up path.
+func (n *node) matchChildren(path, fullPath string, params func() *Params, ps *Params) (Handle, *Params) {
+	if path == "" && n.handle != nil {
+		return n.handle, ps
+	}
 
-					return
+	// Params saved by a branch that leads to no handle are dropped
+	// before trying the next one
+	mark := 0
+	if ps != nil {
+		mark = len(*ps)
+	}
 
-				case catchAll:
-					// Save param value
-					if params != nil {
-						if ps == nil {
-							ps = params()
-						}
-						// Expand slice within preallocated capacity
-						i := len(*ps)
-						*ps = (*ps)[:i+1]
-						(*ps)[i] = Param{
-							Key:   n.path[2:],
-							Value: path,
-						}
+	if path != "" {
+		idxc := path[0]
+		for i, c := range []byte(n.indices) {
+			if c == idxc {
+				child := n.children[i]
+				if strings.HasPrefix(path, child.path) {
+					var handle Handle
+					if handle, ps = child.matchChildren(path[len(child.path):], fullPath, params, ps); handle != nil {
+						return handle, ps
 					}
-
-					handle = n.handle
-					return
-
-				default:
-					panic("invalid node type")
 				}
+				break
 			}
-		} else if path == prefix {
-			// We should have reached the node containing the handle.
-			// Check if this node has a handle registered.
-			if handle = n.handle; handle != nil {
-				return
+		}
+	}
+
+	for _, child := range n.wildChildren() {
+		if ps != nil {
+			*ps = (*ps)[:mark]
+		}
+
+		switch child.nType {
+		case param:
+			if path == "" {
+				continue
 			}
 
-			// If there is no handle for this route, but this route has a
-			// wildcard child, there must be a handle for this path with an
-			// additional trailing slash
-			if path == "/" && n.wildChild && n.nType != root {
-				tsr = true
-				return
+			// Find param end (either '/' or path end)
+			end := 0
+			for end < len(path) && path[end] != '/' {
+				end++
 			}
 
-			if path == "/" && n.nType == static {
-				tsr = true
-				return
+			if child.constraint != nil && !child.constraint.MatchString(path[:end]) {
+				continue
 			}
 
-			// No handle found. Check if a handle for this path + a
-			// trailing slash exists for trailing slash recommendation
-			for i, c := range []byte(n.indices) {
-				if c == '/' {
-					n = n.children[i]
-					tsr = (len(n.path) == 1 && n.handle != nil) ||
-						(n.nType == catchAll && n.children[0].handle != nil)
-					return
-				}
+			ps = saveParam(ps, params, child.path[1:], path[:end])
+
+			var handle Handle
+			if handle, ps = child.matchChildren(path[end:], fullPath, params, ps); handle != nil {
+				return handle, ps
 			}
-			return
+
+		case catchAll:
+			// The value starts with the '/' ending the path of n
+			ps = saveParam(ps, params, child.path[1:], fullPath[len(fullPath)-len(path)-1:])
+			return child.handle, ps
+
+		default:
+			panic("invalid node type")
 		}
+	}
 
-		// Nothing found. We can recommend to redirect to the same URL with an
-		// extra trailing slash if a leaf exists for that path
-		tsr = (path == "/") ||
-			(len(prefix) == len(path)+1 && prefix[len(path)] == '/' &&
-				path == prefix[:len(prefix)-1] && n.handle != nil)
-		return
+	return nil, ps
+}
+
+// Saves a wildcard value, getting the Params from params on first use.
+// Nothing is saved if params is nil.
+func saveParam(ps *Params, params func() *Params, key, value string) *Params {
+	if params == nil {
+		return ps
+	}
+	if ps == nil {
+		ps = params()
+	}
+
+	// Expand slice within preallocated capacity
+	i := len(*ps)
+	*ps = (*ps)[:i+1]
+	(*ps)[i] = Param{
+		Key:   key,
+		Value: value,
+	}
+	return ps
+}
+
+// Returns the handle matching the path of the node itself: its own handle, or
+// else the one of its catch-all child, which also matches an empty value.
+func (n *node) leafHandle() Handle {
+	if n.handle != nil {
+		return n.handle
+	}
+	for _, child := range n.wildChildren() {
+		if child.nType == catchAll {
+			return child.handle
+		}
 	}
+	return nil
 }
 
 // Makes a case-insensitive lookup of the given path and tries to find a handler.
@@ -502,184 +545,187 @@ func shiftNRuneBytes(rb [4]byte, n int) [4]byte {
 	}
 }
 
-// Recursive case-insensitive lookup function used by n.findCaseInsensitivePath
+// Recursive case-insensitive lookup function used by n.findCaseInsensitivePath.
+// Like getValue, it tries static children before the param and the param
+// before the catch-all.
 func (n *node) findCaseInsensitivePathRec(path string, ciPath []byte, rb [4]byte, fixTrailingSlash bool) []byte {
 	npLen := len(n.path)
 
-walk: // Outer loop for walking the tree
-	for len(path) >= npLen && (npLen == 0 || strings.EqualFold(path[1:npLen], n.path[1:])) {
-		// Add common prefix to result
-		oldPath := path
-		path = path[npLen:]
-		ciPath = append(ciPath, n.path...)
-
-		if len(path) > 0 {
-			// If this node does not have a wildcard (param or catchAll) child,
-			// we can just look up the next child node and continue to walk down
-			// the tree
-			if !n.wildChild {
-				// Skip rune bytes already processed
-				rb = shiftNRuneBytes(rb, npLen)
-
-				if rb[0] != 0 {
-					// Old rune not finished
-					idxc := rb[0]
-					for i, c := range []byte(n.indices) {
-						if c == idxc {
-							// continue with child node
-							n = n.children[i]
-							npLen = len(n.path)
-							continue walk
-						}
-					}
-				} else {
-					// Process a new rune
-					var rv rune
-
-					// Find rune start.
-					// Runes are up to 4 byte long,
-					// -4 would definitely be another rune.
-					var off int
-					for max := min(npLen, 3); off < max; off++ {
-						if i := npLen - off; utf8.RuneStart(oldPath[i]) {
-							// read rune from cached path
-							rv, _ = utf8.DecodeRuneInString(oldPath[i:])
-							break
-						}
-					}
+	if len(path) < npLen || (npLen > 0 && !strings.EqualFold(path[1:npLen], n.path[1:])) {
+		// Nothing found.
+		// Try to fix the path by adding / removing a trailing slash
+		if fixTrailingSlash {
+			if path == "/" {
+				return ciPath
+			}
+			if len(path)+1 == npLen && n.path[len(path)] == '/' &&
+				strings.EqualFold(path[1:], n.path[1:len(path)]) && n.leafHandle() != nil {
+				return append(ciPath, n.path...)
+			}
+		}
+		return nil
+	}
 
-					// Calculate lowercase bytes of current rune
-					lo := unicode.ToLower(rv)
-					utf8.EncodeRune(rb[:], lo)
-
-					// Skip already processed bytes
-					rb = shiftNRuneBytes(rb, off)
-
-					idxc := rb[0]
-					for i, c := range []byte(n.indices) {
-						// Lowercase matches
-						if c == idxc {
-							// must use a recursive approach since both the
-							// uppercase byte and the lowercase byte might exist
-							// as an index
-							if out := n.children[i].findCaseInsensitivePathRec(
-								path, ciPath, rb, fixTrailingSlash,
-							); out != nil {
-								return out
-							}
-							break
-						}
-					}
+	// Add common prefix to result
+	oldPath := path
+	path = path[npLen:]
+	ciPath = append(ciPath, n.path...)
 
-					// If we found no match, the same for the uppercase rune,
-					// if it differs
-					if up := unicode.ToUpper(rv); up != lo {
-						utf8.EncodeRune(rb[:], up)
-						rb = shiftNRuneBytes(rb, off)
-
-						idxc := rb[0]
-						for i, c := range []byte(n.indices) {
-							// Uppercase matches
-							if c == idxc {
-								// Continue with child node
-								n = n.children[i]
-								npLen = len(n.path)
-								continue walk
-							}
-						}
+	if len(path) == 0 {
+		// We should have reached the node containing the handle.
+		// Check if this node has a handle registered.
+		if n.leafHandle() != nil {
+			return ciPath
+		}
+
+		// No handle found.
+		// Try to fix the path by adding a trailing slash
+		if fixTrailingSlash {
+			for i, c := range []byte(n.indices) {
+				if c == '/' {
+					if n := n.children[i]; n.path == "/" && n.leafHandle() != nil {
+						return append(ciPath, '/')
 					}
+					return nil
 				}
+			}
+		}
+		return nil
+	}
 
-				// Nothing found. We can recommend to redirect to the same URL
-				// without a trailing slash if a leaf exists for that path
-				if fixTrailingSlash && path == "/" && n.handle != nil {
-					return ciPath
+	// Skip rune bytes already processed
+	rb = shiftNRuneBytes(rb, npLen)
+
+	if rb[0] != 0 {
+		// Old rune not finished
+		idxc := rb[0]
+		for i, c := range []byte(n.indices) {
+			if c == idxc {
+				// continue with child node
+				if out := n.children[i].findCaseInsensitivePathRec(
+					path, ciPath, rb, fixTrailingSlash,
+				); out != nil {
+					return out
 				}
-				return nil
+				break
+			}
+		}
+	} else {
+		// Process a new rune
+		var rv rune
+
+		// Find rune start.
+		// Runes are up to 4 byte long,
+		// -4 would definitely be another rune.
+		var off int
+		for max := min(npLen, 3); off < max; off++ {
+			if i := npLen - off; utf8.RuneStart(oldPath[i]) {
+				// read rune from cached path
+				rv, _ = utf8.DecodeRuneInString(oldPath[i:])
+				break
 			}
+		}
 
-			n = n.children[0]
-			switch n.nType {
-			case param:
-				// Find param end (either '/' or path end)
-				end := 0
-				for end < len(path) && path[end] != '/' {
-					end++
+		// Calculate lowercase bytes of current rune
+		lo := unicode.ToLower(rv)
+		utf8.EncodeRune(rb[:], lo)
+
+		// Skip already processed bytes
+		rb = shiftNRuneBytes(rb, off)
+
+		idxc := rb[0]
+		for i, c := range []byte(n.indices) {
+			// Lowercase matches
+			if c == idxc {
+				// must use a recursive approach since both the
+				// uppercase byte and the lowercase byte might exist
+				// as an index
+				if out := n.children[i].findCaseInsensitivePathRec(
+					path, ciPath, rb, fixTrailingSlash,
+				); out != nil {
+					return out
 				}
+				break
+			}
+		}
 
-				// Add param value to case insensitive path
-				ciPath = append(ciPath, path[:end]...)
-
-				// We need to go deeper!
-				if end < len(path) {
-					if len(n.children) > 0 {
-						// Continue with child node
-						n = n.children[0]
-						npLen = len(n.path)
-						path = path[end:]
-						continue
-					}
+		// If we found no match, the same for the uppercase rune,
+		// if it differs
+		if up := unicode.ToUpper(rv); up != lo {
+			utf8.EncodeRune(rb[:], up)
+			rb = shiftNRuneBytes(rb, off)
 
-					// ... but we can't
-					if fixTrailingSlash && len(path) == end+1 {
-						return ciPath
+			idxc := rb[0]
+			for i, c := range []byte(n.indices) {
+				// Uppercase matches
+				if c == idxc {
+					if out := n.children[i].findCaseInsensitivePathRec(
+						path, ciPath, rb, fixTrailingSlash,
+					); out != nil {
+						return out
 					}
-					return nil
+					break
 				}
+			}
+		}
+	}
 
-				if n.handle != nil {
-					return ciPath
-				} else if fixTrailingSlash && len(n.children) == 1 {
-					// No handle found. Check if a handle for this path + a
-					// trailing slash exists
-					n = n.children[0]
-					if n.path == "/" && n.handle != nil {
-						return append(ciPath, '/')
+	// No static child matched, try the wildcards
+	for _, child := range n.wildChildren() {
+		switch child.nType {
+		case param:
+			// Find param end (either '/' or path end)
+			end := 0
+			for end < len(path) && path[end] != '/' {
+				end++
+			}
+
+			if child.constraint != nil && !child.constraint.MatchString(path[:end]) {
+				continue
+			}
+
+			// Add param value to case insensitive path
+			ciParam := append(ciPath, path[:end]...)
+
+			// We need to go deeper!
+			if end < len(path) {
+				if len(child.children) > 0 {
+					if out := child.children[0].findCaseInsensitivePathRec(
+						path[end:], ciParam, [4]byte{}, fixTrailingSlash,
+					); out != nil {
+						return out
 					}
 				}
-				return nil
-
-			case catchAll:
-				return append(ciPath, path...)
 
-			default:
-				panic("invalid node type")
-			}
-		} else {
-			// We should have reached the node containing the handle.
-			// Check if this node has a handle registered.
-			if n.handle != nil {
-				return ciPath
+				// ... but we can't
+				if fixTrailingSlash && len(path) == end+1 && child.handle != nil {
+					return ciParam
+				}
+				continue
 			}
 
-			// No handle found.
-			// Try to fix the path by adding a trailing slash
-			if fixTrailingSlash {
-				for i, c := range []byte(n.indices) {
-					if c == '/' {
-						n = n.children[i]
-						if (len(n.path) == 1 && n.handle != nil) ||
-							(n.nType == catchAll && n.children[0].handle != nil) {
-							return append(ciPath, '/')
-						}
-						return nil
-					}
+			if child.handle != nil {
+				return ciParam
+			} else if fixTrailingSlash && len(child.children) == 1 {
+				// No handle found. Check if a handle for this path + a
+				// trailing slash exists
+				if n := child.children[0]; n.path == "/" && n.leafHandle() != nil {
+					return append(ciParam, '/')
 				}
 			}
-			return nil
+
+		case catchAll:
+			return append(ciPath, path...)
+
+		default:
+			panic("invalid node type")
 		}
 	}
 
-	// Nothing found.
-	// Try to fix the path by adding / removing a trailing slash
-	if fixTrailingSlash {
-		if path == "/" {
-			return ciPath
-		}
-		if len(path)+1 == npLen && n.path[len(path)] == '/' &&
-			strings.EqualFold(path[1:], n.path[1:len(path)]) && n.handle != nil {
-			return append(ciPath, n.path...)
-		}
+	// Nothing found. We can recommend to redirect to the same URL
+	// without a trailing slash if a leaf exists for that path
+	if fixTrailingSlash && path == "/" && n.handle != nil {
+		return ciPath
 	}
 	return nil
 }
@@ -14,7 +14,7 @@ import (
 )
 
 // func printChildren(n *node, prefix string) {
-// 	fmt.Printf(" %02d %s%s[%d] %v %t %d \r\n", n.priority, prefix, n.path, len(n.children), n.handle, n.wildChild, n.nType)
+// 	fmt.Printf(" %02d %s%s[%d] %v %s %d \r\n", n.priority, prefix, n.path, len(n.children), n.handle, n.indices, n.nType)
 // 	for l := len(n.path); l > 0; l-- {
 // 		prefix += " "
 // 	}
@@ -225,20 +225,23 @@ func testRoutes(t *testing.T, routes []testRoute) {
 func TestTreeWildcardConflict(t *testing.T) {
 	routes := []testRoute{
 		{"/cmd/:tool/:sub", false},
-		{"/cmd/vet", true},
+		{"/cmd/vet", false},
+		{"/cmd/:command", true},
 		{"/src/*filepath", false},
 		{"/src/*filepathx", true},
-		{"/src/", true},
+		{"/src/", false},
 		{"/src1/", false},
-		{"/src1/*filepath", true},
+		{"/src1/*filepath", false},
 		{"/src2*filepath", true},
 		{"/search/:query", false},
-		{"/search/invalid", true},
+		{"/search/invalid", false},
+		{"/search/:q", true},
 		{"/user_:name", false},
-		{"/user_x", true},
+		{"/user_x", false},
 		{"/user_:name", false},
+		{"/user_:id", true},
 		{"/id:id", false},
-		{"/id/:id", true},
+		{"/id/:id", false},
 	}
 	testRoutes(t, routes)
 }
@@ -246,15 +249,17 @@ func TestTreeWildcardConflict(t *testing.T) {
 func TestTreeChildConflict(t *testing.T) {
 	routes := []testRoute{
 		{"/cmd/vet", false},
-		{"/cmd/:tool/:sub", true},
+		{"/cmd/:tool/:sub", false},
 		{"/src/AUTHORS", false},
-		{"/src/*filepath", true},
+		{"/src/*filepath", false},
 		{"/user_x", false},
-		{"/user_:name", true},
+		{"/user_:name", false},
 		{"/id/:id", false},
-		{"/id:id", true},
-		{"/:id", true},
-		{"/*filepath", true},
+		{"/id:id", false},
+		{"/:id", false},
+		{"/*filepath", false},
+		{"/src/:file", false},
+		{"/src/*path", true},
 	}
 	testRoutes(t, routes)
 }
@@ -329,12 +334,82 @@ func TestTreeCatchAllConflict(t *testing.T) {
 	testRoutes(t, routes)
 }
 
-func TestTreeCatchAllConflictRoot(t *testing.T) {
-	routes := []testRoute{
-		{"/", false},
-		{"/*filepath", true},
+func TestTreeCatchAllRoot(t *testing.T) {
+	tree := &node{}
+	tree.addRoute("/", fakeHandler("/"))
+	tree.addRoute("/*filepath", fakeHandler("/*filepath"))
+
+	checkRequests(t, tree, testRequests{
+		{"/", false, "/", nil},
+		{"/index.html", false, "/*filepath", Params{Param{"filepath", "/index.html"}}},
+	})
+
+	checkPriorities(t, tree)
+}
+
+func TestTreeWildcardPriority(t *testing.T) {
+	routes := []string{
+		"/users/me",
+		"/users/me/settings",
+		"/users/:id",
+		"/users/:id/posts",
+		"/users/*rest",
+		"/files/static/app.js",
+		"/files/:dir/index.html",
+		"/files/*filepath",
+		"/a/b/c",
+		"/a/:x/d",
+		"/a/:x/:y/e",
+		"/a/*rest",
+		"/user_x",
+		"/user_:name",
+	}
+
+	requests := testRequests{
+		{"/users/me", false, "/users/me", nil},
+		{"/users/me/settings", false, "/users/me/settings", nil},
+		{"/users/42", false, "/users/:id", Params{Param{"id", "42"}}},
+		{"/users/m", false, "/users/:id", Params{Param{"id", "m"}}},
+		{"/users/mee", false, "/users/:id", Params{Param{"id", "mee"}}},
+		{"/users/me/posts", false, "/users/:id/posts", Params{Param{"id", "me"}}},
+		{"/users/42/posts", false, "/users/:id/posts", Params{Param{"id", "42"}}},
+		{"/users/me/other", false, "/users/*rest", Params{Param{"rest", "/me/other"}}},
+		{"/users/42/posts/1", false, "/users/*rest", Params{Param{"rest", "/42/posts/1"}}},
+		{"/users/", false, "/users/*rest", Params{Param{"rest", "/"}}},
+		{"/files/static/app.js", false, "/files/static/app.js", nil},
+		{"/files/static/index.html", false, "/files/:dir/index.html", Params{Param{"dir", "static"}}},
+		{"/files/static/other.js", false, "/files/*filepath", Params{Param{"filepath", "/static/other.js"}}},
+		{"/a/b/c", false, "/a/b/c", nil},
+		{"/a/b/d", false, "/a/:x/d", Params{Param{"x", "b"}}},
+		{"/a/b/c/e", false, "/a/:x/:y/e", Params{Param{"x", "b"}, Param{"y", "c"}}},
+		{"/a/b/c/f", false, "/a/*rest", Params{Param{"rest", "/b/c/f"}}},
+		{"/a/b", false, "/a/*rest", Params{Param{"rest", "/b"}}},
+		{"/user_x", false, "/user_x", nil},
+		{"/user_xy", false, "/user_:name", Params{Param{"name", "xy"}}},
+		{"/user_", true, "", nil},
+	}
+
+	// The precedence does not depend on the registration order
+	for _, reverse := range []bool{false, true} {
+		tree := &node{}
+		for i := range routes {
+			route := routes[i]
+			if reverse {
+				route = routes[len(routes)-1-i]
+			}
+			tree.addRoute(route, fakeHandler(route))
+		}
+
+		checkRequests(t, tree, requests)
+		checkPriorities(t, tree)
+
+		if out, found := tree.findCaseInsensitivePath("/USERS/ME/POSTS", true); !found || out != "/users/ME/posts" {
+			t.Errorf("Wrong result for '/USERS/ME/POSTS': got %s, %t", out, found)
+		}
+		if out, found := tree.findCaseInsensitivePath("/FILES/STATIC/APP.JS", true); !found || out != "/files/static/app.js" {
+			t.Errorf("Wrong result for '/FILES/STATIC/APP.JS': got %s, %t", out, found)
+		}
 	}
-	testRoutes(t, routes)
 }
 
 func TestTreeCatchMaxParams(t *testing.T) {
@@ -659,6 +734,52 @@ func TestTreeInvalidNodeType(t *testing.T) {
 	}
 }
 
+func TestTreeConstrainedParams(t *testing.T) {
+	integer := regexp.MustCompile(`^[0-9]+$`)
+	lower := regexp.MustCompile(`^[a-z]+$`)
+
+	routes := []struct {
+		path        string
+		constraints map[string]*regexp.Regexp
+	}{
+		{"/users/:slug", nil},
+		{"/users/:id", map[string]*regexp.Regexp{"id": integer}},
+		{"/users/:name", map[string]*regexp.Regexp{"name": lower}},
+		{"/users/:id/posts", map[string]*regexp.Regexp{"id": regexp.MustCompile(`^[0-9]+$`)}},
+		{"/users/me", nil},
+		{"/v/:ver/*rest", map[string]*regexp.Regexp{"ver": integer}},
+	}
+
+	tree := &node{}
+	for _, route := range routes {
+		tree.addConstrainedRoute(route.path, route.constraints, fakeHandler(route.path))
+	}
+
+	checkRequests(t, tree, testRequests{
+		{"/users/me", false, "/users/me", nil},
+		{"/users/42", false, "/users/:id", Params{Param{"id", "42"}}},
+		{"/users/gopher", false, "/users/:name", Params{Param{"name", "gopher"}}},
+		{"/users/Gopher-42", false, "/users/:slug", Params{Param{"slug", "Gopher-42"}}},
+		{"/users/42/posts", false, "/users/:id/posts", Params{Param{"id", "42"}}},
+		{"/v/2/docs", false, "/v/:ver/*rest", Params{Param{"ver", "2"}, Param{"rest", "/docs"}}},
+		{"/v/two/docs", true, "", nil},
+	})
+
+	checkPriorities(t, tree)
+
+	if out, found := tree.findCaseInsensitivePath("/V/TWO/DOCS", true); found {
+		t.Errorf("Found '/V/TWO/DOCS' despite the constraint: %s", out)
+	}
+
+	// Params with the same constraint must have the same name
+	recv := catchPanic(func() {
+		tree.addConstrainedRoute("/users/:uid/likes", map[string]*regexp.Regexp{"uid": integer}, nil)
+	})
+	if recv == nil {
+		t.Error("no panic for conflicting route '/users/:uid/likes'")
+	}
+}
+
 func TestTreeWildcardConflictEx(t *testing.T) {
 	conflicts := [...]struct {
 		route        string
@@ -666,11 +787,10 @@ func TestTreeWildcardConflictEx(t *testing.T) {
 		existPath    string
 		existSegPath string
 	}{
-		{"/who/are/foo", "/foo", `/who/are/\*you`, `/\*you`},
-		{"/who/are/foo/", "/foo/", `/who/are/\*you`, `/\*you`},
-		{"/who/are/foo/bar", "/foo/bar", `/who/are/\*you`, `/\*you`},
-		{"/conxxx", "xxx", `/con:tact`, `:tact`},
-		{"/conooo/xxx", "ooo", `/con:tact`, `:tact`},
+		{"/who/are/*me", `\*me`, `/who/are/\*you`, `\*you`},
+		{"/who/are/*youth", `\*youth`, `/who/are/\*you`, `\*you`},
+		{"/con:nection", ":nection", `/con:tact`, `:tact`},
+		{"/con:nection/xxx", ":nection", `/con:tact`, `:tact`},
 	}
 
 	for i := range conflicts {
@@ -0,0 +1,105 @@
+package lura
+
+import (
+	"context"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/proxy"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// IncompleteError is returned when required backends of an endpoint fail to answer.
+type IncompleteError struct {
+	// Backends lists the names of the required backends that failed.
+	Backends []string
+
+	// Status is the status of the response.
+	Status int
+}
+
+func (e IncompleteError) Error() string {
+	return "required backends failed: " + strings.Join(e.Backends, ", ")
+}
+
+// StatusCode returns the status of the response.
+func (e IncompleteError) StatusCode() int {
+	return e.Status
+}
+
+type completenessCtxKey struct{}
+
+// completeness records the required backends that answered a request successfully.
+type completeness struct {
+	mu        sync.Mutex
+	succeeded map[*config.Backend]bool
+}
+
+func (c *completeness) succeed(remote *config.Backend) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.succeeded[remote] = true
+}
+
+func (c *completeness) failed(remotes []*config.Backend) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	failed := make([]string, 0)
+	for _, remote := range remotes {
+		if !c.succeeded[remote] {
+			failed = append(failed, backendOptions(remote).Name)
+		}
+	}
+	return failed
+}
+
+// newCompletenessMiddleware fails the requests for which a required backend of the endpoint did not answer
+// successfully in time, rather than answering with the data of the other backends.
+// The backends report their success with newRequiredMiddleware.
+func newCompletenessMiddleware(cfg *config.EndpointConfig) proxy.Middleware {
+	required := make([]*config.Backend, 0)
+	for _, remote := range cfg.Backend {
+		if backendOptions(remote).Required {
+			required = append(required, remote)
+		}
+	}
+
+	status := endpointOptions(cfg).IncompleteStatus
+	if status == 0 {
+		status = http.StatusBadGateway
+	}
+
+	return func(next ...proxy.Proxy) proxy.Proxy {
+		if len(required) == 0 {
+			return next[0]
+		}
+
+		return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+			c := &completeness{succeeded: make(map[*config.Backend]bool, len(required))}
+			response, err := next[0](context.WithValue(ctx, completenessCtxKey{}, c), request)
+
+			if failed := c.failed(required); len(failed) > 0 {
+				return nil, IncompleteError{Backends: failed, Status: status}
+			}
+
+			return response, err
+		}
+	}
+}
+
+// newRequiredMiddleware reports the complete answers of a required backend to newCompletenessMiddleware.
+func newRequiredMiddleware(remote *config.Backend) proxy.Middleware {
+	return func(next ...proxy.Proxy) proxy.Proxy {
+		return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+			response, err := next[0](ctx, request)
+			if err == nil && response != nil && response.IsComplete {
+				if c, ok := ctx.Value(completenessCtxKey{}).(*completeness); ok {
+					c.succeed(remote)
+				}
+			}
+			return response, err
+		}
+	}
+}
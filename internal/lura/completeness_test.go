@@ -0,0 +1,73 @@
+package lura
+
+import (
+	"context"
+	"errors"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/proxy"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestCompletenessMiddleware(t *testing.T) {
+	users := &config.Backend{ExtraConfig: config.ExtraConfig{Namespace: BackendOptions{Name: "users", Required: true}}}
+	avatars := &config.Backend{ExtraConfig: config.ExtraConfig{Namespace: BackendOptions{Name: "avatars"}}}
+	cfg := &config.EndpointConfig{
+		Backend:     []*config.Backend{users, avatars},
+		ExtraConfig: config.ExtraConfig{Namespace: EndpointOptions{IncompleteStatus: http.StatusServiceUnavailable}},
+	}
+
+	backend := func(data map[string]interface{}, err error) proxy.Proxy {
+		return func(context.Context, *proxy.Request) (*proxy.Response, error) {
+			if err != nil {
+				return nil, err
+			}
+			return &proxy.Response{Data: data, IsComplete: true}, nil
+		}
+	}
+	// merge calls the backends in turn and answers with the data of those that succeeded
+	merge := func(usersProxy, avatarsProxy proxy.Proxy) proxy.Proxy {
+		return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+			response := &proxy.Response{Data: map[string]interface{}{}, IsComplete: true}
+			for _, p := range []proxy.Proxy{newRequiredMiddleware(users)(usersProxy), avatarsProxy} {
+				r, err := p(ctx, request)
+				if err != nil {
+					response.IsComplete = false
+					continue
+				}
+				for k, v := range r.Data {
+					response.Data[k] = v
+				}
+			}
+			return response, nil
+		}
+	}
+
+	p := newCompletenessMiddleware(cfg)(merge(
+		backend(map[string]interface{}{"name": "alice"}, nil),
+		backend(nil, errors.New("avatars are down")),
+	))
+	response, err := p(context.Background(), &proxy.Request{})
+	assert.NoError(t, err, "optional backends may fail")
+	assert.Equal(t, map[string]interface{}{"name": "alice"}, response.Data)
+	assert.False(t, response.IsComplete)
+
+	p = newCompletenessMiddleware(cfg)(merge(
+		backend(nil, errors.New("users are down")),
+		backend(map[string]interface{}{"avatar": "alice.png"}, nil),
+	))
+	response, err = p(context.Background(), &proxy.Request{})
+	assert.Nil(t, response)
+	var incomplete IncompleteError
+	if assert.ErrorAs(t, err, &incomplete) {
+		assert.Equal(t, []string{"users"}, incomplete.Backends)
+		assert.Equal(t, http.StatusServiceUnavailable, incomplete.StatusCode())
+	}
+
+	cfg.ExtraConfig = nil
+	_, err = newCompletenessMiddleware(cfg)(merge(backend(nil, errors.New("users are down")), backend(nil, nil)))(context.Background(), &proxy.Request{})
+	if assert.ErrorAs(t, err, &incomplete) {
+		assert.Equal(t, http.StatusBadGateway, incomplete.StatusCode())
+	}
+}
@@ -12,14 +12,19 @@ import (
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"github.com/luraproject/lura/v2/config"
 	"github.com/luraproject/lura/v2/core"
+	"github.com/luraproject/lura/v2/encoding"
 	"github.com/luraproject/lura/v2/logging"
 	"github.com/luraproject/lura/v2/proxy"
 	"github.com/luraproject/lura/v2/router"
 	"github.com/luraproject/lura/v2/router/mux"
+	"github.com/luraproject/lura/v2/sd"
+	"github.com/luraproject/lura/v2/transport/http/client"
 	"github.com/luraproject/lura/v2/transport/http/server"
 	"github.com/xico42/caddy-lura/internal/httprouter"
 	"net/http"
 	"net/textproto"
+	"net/url"
+	"sort"
 	"strings"
 )
 
@@ -44,69 +49,410 @@ func registerEndpoints(luraRouter *httprouter.Router, proxyFactory proxy.Factory
 		}
 	}
 
+	methodsByRoute := map[string]map[string]bool{}
 	for _, c := range opts.ServiceConfig.Endpoints {
+		key := routeKey(c)
+		if methodsByRoute[key] == nil {
+			methodsByRoute[key] = map[string]bool{}
+		}
+		methodsByRoute[key][strings.ToUpper(c.Method)] = true
+	}
+
+	// GET endpoints serve the HEAD requests of their route too, unless the route has a HEAD endpoint
+	// or serving them has side effects. WebSocket and stream endpoints serve GET requests only.
+	heads := map[*config.EndpointConfig]bool{}
+	for _, c := range opts.ServiceConfig.Endpoints {
+		if strings.ToUpper(c.Method) != http.MethodGet || methodsByRoute[routeKey(c)][http.MethodHead] {
+			continue
+		}
+		if o := endpointOptions(c); o.WebSocket == nil && o.Stream == nil && !hasSideEffects(c) {
+			heads[c] = true
+		}
+	}
+	for c := range heads {
+		methodsByRoute[routeKey(c)][http.MethodHead] = true
+	}
+
+	routes := newRouteTable()
+	addRoute := func(method, path string, c *config.EndpointConfig, handle httprouter.Handle) {
+		constraints := endpointOptions(c).Constraints
+		if err := routes.add(method, path, constraints, routeKey(c), endpointOptions(c).Matchers, handle); err != nil {
+			logger.Error(logPrefix, err.Error(), "Ignoring")
+		}
+	}
+
+endpoints:
+	for _, c := range opts.ServiceConfig.Endpoints {
+		path := c.Endpoint
+		catchAll := endpointOptions(c).CatchAll
+		if catchAll != "" {
+			// lura knows the catch-all as a plain parameter
+			path = strings.TrimSuffix(path, ":"+catchAll) + "*" + catchAll
+		}
+
+		if ws := endpointOptions(c).WebSocket; ws != nil {
+			logger.Debug(logPrefix, "Registering the websocket endpoint", path)
+			addRoute(http.MethodGet, path, c, withCatchAll(catchAll, newWebSocketHandle(c, *ws, opts.resources.clients, logger)))
+			continue
+		}
+
+		if stream := endpointOptions(c).Stream; stream != nil {
+			logger.Debug(logPrefix, "Registering the stream endpoint", path)
+			addRoute(http.MethodGet, path, c, withCatchAll(catchAll, newStreamHandle(c, *stream, opts.resources.clients, logger)))
+			continue
+		}
+
 		proxyStack, err := proxyFactory.New(c)
 		if err != nil {
 			logger.Error(logPrefix, "could not instantiate the proxy stack", err.Error())
 			continue
 		}
 
-		handler := buildEndpointHandle(c, proxyStack)
+		handler := withCatchAll(catchAll, withVariants(c, buildEndpointHandle(c, proxyStack, opts.renders.get(c))))
 
 		method := strings.ToTitle(c.Method)
-		path := c.Endpoint
+		methods := methodsByRoute[routeKey(c)]
 		if method != http.MethodGet && len(c.Backend) > 1 {
 			if !router.IsValidSequentialEndpoint(c) {
 				logger.Error(logPrefix, method, " endpoints with sequential proxy enabled only allow a non-GET in the last backend! Ignoring", path)
-				return
+				break endpoints
 			}
 		}
 
 		switch method {
 		case http.MethodGet:
+		case http.MethodHead:
 		case http.MethodPost:
 		case http.MethodPut:
 		case http.MethodPatch:
 		case http.MethodDelete:
+		case http.MethodOptions:
+			handler = withAllowHeader(allowHeader(methods), handler)
 		default:
 			logger.Error(logPrefix, "Unsupported method", method)
-			return
+			break endpoints
 		}
 		logger.Debug(logPrefix, "Registering the endpoint", method, path)
 
-		luraRouter.Handle(method, path, handler)
+		addRoute(method, path, c, handler)
+
+		if heads[c] {
+			logger.Debug(logPrefix, "Registering the endpoint", http.MethodHead, path)
+			addRoute(http.MethodHead, path, c, handler)
+		}
+	}
+
+	routes.register(luraRouter)
+}
+
+// withCatchAll cleans the value of the catch-all parameter name before passing it to next, so that it cannot
+// climb above the path it is appended to when forwarded to the backends. It returns next as is if name is empty.
+func withCatchAll(name string, next httprouter.Handle) httprouter.Handle {
+	if name == "" {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+		for i := range params {
+			if params[i].Key == name {
+				params[i].Value = httprouter.CleanPath(params[i].Value)
+				break
+			}
+		}
+		return next(w, r, params)
 	}
 }
 
-func newProxyFactory(logger logging.Logger) proxy.Factory {
-	return proxy.NewDefaultFactory(newBackendFactory(), logger)
+// routeKey identifies the route of an endpoint: its path along with the constraints of its parameters,
+// since endpoints with the same path but different constraints are different routes.
+func routeKey(c *config.EndpointConfig) string {
+	constraints := endpointOptions(c).Constraints
+	if len(constraints) == 0 {
+		return c.Endpoint
+	}
+
+	names := make([]string, 0, len(constraints))
+	for name := range constraints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString(c.Endpoint)
+	for _, name := range names {
+		sb.WriteString(" " + name + "=" + constraints[name].String())
+	}
+	return sb.String()
+}
+
+// hasSideEffects tells whether serving the endpoint does more than reading from its backends: pulling messages
+// from a subscription, or mirroring the request to shadow backends.
+func hasSideEffects(c *config.EndpointConfig) bool {
+	for _, remote := range c.Backend {
+		opts := backendOptions(remote)
+		if opts.Shadow != nil || (opts.PubSub != nil && opts.PubSub.Pull) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowHeader returns the value of the Allow header for a path served with the given methods.
+// OPTIONS is always allowed.
+func allowHeader(methods map[string]bool) string {
+	allowed := make([]string, 0, len(methods)+1)
+	for m := range methods {
+		allowed = append(allowed, m)
+	}
+	if !methods[http.MethodOptions] {
+		allowed = append(allowed, http.MethodOptions)
+	}
+	sort.Strings(allowed)
+
+	return strings.Join(allowed, ", ")
+}
+
+// withAllowHeader sets the Allow header of the responses of explicit OPTIONS endpoints.
+func withAllowHeader(allow string, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+		w.Header().Set("Allow", allow)
+		return next(w, r, params)
+	}
+}
+
+// headResponseWriter discards the body of the responses to HEAD requests, served by GET endpoints.
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w headResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w headResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func newProxyFactory(logger logging.Logger, opts Opts) proxy.Factory {
+	factory := proxy.NewDefaultFactory(newBackendFactory(opts), logger)
+	return proxy.FactoryFunc(func(cfg *config.EndpointConfig) (proxy.Proxy, error) {
+		opts := endpointOptions(cfg)
+
+		if opts.Stub {
+			p := proxy.NewStaticMiddleware(logger, cfg)(stubProxy)
+			return newTransformMiddleware(opts.Transform)(p), nil
+		}
+
+		primary, remotes := splitShadows(cfg)
+		p, err := factory.New(primary)
+		if err != nil {
+			return nil, err
+		}
+		p = newCompletenessMiddleware(primary)(p)
+		p = newFallbackReportMiddleware(primary)(p)
+
+		shadows, err := newShadows(factory, cfg, remotes)
+		if err != nil {
+			return nil, err
+		}
+
+		return newShadowMiddleware(logger, shadows...)(newTransformMiddleware(opts.Transform)(p)), nil
+	})
+}
+
+// stubProxy answers for endpoints that have no backends, leaving the response to be filled with static data.
+func stubProxy(_ context.Context, _ *proxy.Request) (*proxy.Response, error) {
+	return &proxy.Response{Data: map[string]interface{}{}, IsComplete: true}, nil
 }
 
-func newBackendFactory() proxy.BackendFactory {
+func newBackendFactory(opts Opts) proxy.BackendFactory {
 	return func(remote *config.Backend) proxy.Proxy {
-		next := backendHttpProxy(remote)
-		return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
-			request.GeneratePath(remote.URLPattern)
-			request.Params = nil
+		next := newBackendProxy(remote, opts)
+		backendOpts := backendOptions(remote)
+
+		var balancers map[string]sd.Balancer
+		if backendOpts.Split != nil {
+			balancers = variantBalancers(remote, *backendOpts.Split)
+		}
+
+		var p proxy.Proxy = func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
 			replacer, ok := ctx.Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
 			if !ok {
 				return nil, errors.New("could not find caddy replacer")
 			}
+
+			urlPattern := remote.URLPattern
+			var variant *VariantOptions
+			if backendOpts.Split != nil {
+				variant = backendOpts.Split.variant(replacer, backendOpts.Name)
+				if variant == nil {
+					return nil, errors.New("no variant to send the request to")
+				}
+				urlPattern = variant.URLPattern
+			}
+
+			request.GeneratePath(urlPattern)
+			request.Params = nil
 			path, err := replacer.ReplaceOrErr(request.Path, true, true)
 			if err != nil {
 				return nil, err
 			}
 			request.Path = path
+
+			if variant != nil {
+				request.URL, err = variantURL(balancers[variant.Name], path, request.URL)
+				if err != nil {
+					return nil, err
+				}
+				return next(ctx, request)
+			}
+
 			request.URL.Path = path
 			return next(ctx, request)
 		}
+
+		if backendOpts.Timeout > 0 {
+			p = newTimeoutMiddleware(backendOpts.Timeout)(p)
+		}
+		if backendOpts.Hedge != nil {
+			p = newHedgeMiddleware(remote, *backendOpts.Hedge)(p)
+		}
+		if backendOpts.Retry != nil {
+			p = newRetryMiddleware(*backendOpts.Retry)(p)
+		}
+		if backendOpts.Shadow == nil {
+			p = newShadowCaptureMiddleware(remote)(p)
+		}
+		if backendOpts.Required {
+			p = newRequiredMiddleware(remote)(p)
+		}
+		if backendOpts.Fallback != nil {
+			p = newFallbackMiddleware(remote, *backendOpts.Fallback)(p)
+		}
+
+		return p
+	}
+}
+
+// resolveBackendURL picks a backend host and appends the url pattern resolved with the caddy replacer,
+// for endpoints that reach their backends outside the proxy stack.
+func resolveBackendURL(balancer sd.Balancer, replacer *caddy.Replacer, urlPattern, rawQuery string) (string, error) {
+	host, err := balancer.Host()
+	if err != nil {
+		return "", err
+	}
+
+	path, err := replacer.ReplaceOrErr(urlPattern, true, true)
+	if err != nil {
+		return "", err
+	}
+
+	// parameter values are unescaped, as in the request path
+	u := host + (&url.URL{Path: path}).EscapedPath()
+	if rawQuery != "" {
+		u += "?" + rawQuery
+	}
+
+	return u, nil
+}
+
+// newBackendProxy creates the proxy talking to the backend, according to its type.
+func newBackendProxy(remote *config.Backend, opts Opts) proxy.Proxy {
+	backendOpts := backendOptions(remote)
+
+	if backendOpts.GRPC != nil {
+		conns := newGRPCConnPool(backendOpts.Transport.tlsConfig())
+		opts.resources.onClose(conns.close)
+		return newGRPCProxy(remote, *backendOpts.GRPC, conns, backendOpts.transforms()...)
+	}
+
+	if backendOpts.PubSub != nil {
+		conn := newPubSubConn(*backendOpts.PubSub)
+		opts.resources.onClose(conn.close)
+		return newPubSubProxy(remote, conn, backendOpts.transforms()...)
+	}
+
+	p := newHTTPProxy(remote, opts)
+	if backendOpts.GraphQL != nil {
+		p = newGraphQLMiddleware(*backendOpts.GraphQL)(p)
+	}
+
+	return p
+}
+
+func newHTTPProxy(remote *config.Backend, opts Opts) proxy.Proxy {
+	re := newHTTPRequestExecutor(remote, opts)
+	backendOpts := backendOptions(remote)
+
+	statusHandler := client.GetHTTPStatusHandler(remote)
+	responseParser := newHTTPResponseParser(remote, backendOpts.transforms()...)
+	if remote.Encoding == encoding.NOOP {
+		statusHandler = client.NoOpHTTPStatusHandler
+		responseParser = proxy.NoOpHTTPResponseParser
+	}
+
+	if backendOpts.Retry != nil {
+		statusHandler = newRetryStatusHandler(*backendOpts.Retry, statusHandler)
+	}
+
+	return proxy.NewHTTPProxyDetailed(remote, re, statusHandler, responseParser)
+}
+
+func newHTTPRequestExecutor(remote *config.Backend, opts Opts) client.HTTPRequestExecutor {
+	c := opts.resources.clients.get(backendOptions(remote).Transport)
+	re := newServerDispatcher(opts.Servers, opts.ServerLookup, client.DefaultHTTPRequestExecutor(func(context.Context) *http.Client { return c }))
+	if opts.Mock == nil {
+		return re
+	}
+
+	f := newFixtures(*opts.Mock, backendOptions(remote).Name)
+	if opts.Mock.Record {
+		return f.record(re)
+	}
+
+	return f.replay
+}
+
+// newHTTPResponseParser decodes the backend response and formats it with newResponseFormatter.
+func newHTTPResponseParser(remote *config.Backend, transforms ...Transformer) proxy.HTTPResponseParser {
+	decode := proxy.DefaultHTTPResponseParserFactory(proxy.HTTPResponseParserConfig{
+		Decoder:         remote.Decoder,
+		EntityFormatter: proxy.DefaultHTTPResponseParserConfig.EntityFormatter,
+	})
+	format := newResponseFormatter(remote, transforms...)
+
+	return func(ctx context.Context, resp *http.Response) (*proxy.Response, error) {
+		response, err := decode(ctx, resp)
+		if err != nil {
+			return nil, err
+		}
+
+		return format(response)
+	}
+}
+
+// newResponseFormatter applies the transforms to the raw backend document, in order,
+// before lura's entity formatter handles allow, mapping and group.
+func newResponseFormatter(remote *config.Backend, transforms ...Transformer) func(*proxy.Response) (*proxy.Response, error) {
+	formatter := proxy.NewEntityFormatter(remote)
+
+	return func(response *proxy.Response) (*proxy.Response, error) {
+		var err error
+		for _, transform := range transforms {
+			response.Data, err = transform(response.Data)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		formatted := formatter.Format(*response)
+		return &formatted, nil
 	}
 }
 
-func buildEndpointHandle(configuration *config.EndpointConfig, prxy proxy.Proxy) httprouter.Handle {
+func buildEndpointHandle(configuration *config.EndpointConfig, prxy proxy.Proxy, render Render) httprouter.Handle {
 	cacheControlHeaderValue := fmt.Sprintf("public, max-age=%d", int(configuration.CacheTTL.Seconds()))
 	isCacheEnabled := configuration.CacheTTL.Seconds() != 0
-	render := getRender(configuration)
 
 	headersToSend := configuration.HeadersToPass
 	if len(headersToSend) == 0 {
@@ -116,7 +462,9 @@ func buildEndpointHandle(configuration *config.EndpointConfig, prxy proxy.Proxy)
 
 	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) (err error) {
 		w.Header().Set(core.KrakendHeaderName, core.KrakendHeaderValue)
-		if r.Method != method {
+		if r.Method == http.MethodHead && method == http.MethodGet {
+			w = headResponseWriter{w}
+		} else if r.Method != method {
 			w.Header().Set(server.CompleteResponseHeaderName, server.HeaderIncompleteResponseValue)
 			http.Error(w, "", http.StatusMethodNotAllowed)
 			return caddyhttp.Error(http.StatusMethodNotAllowed, fmt.Errorf("unexepected method: %s", r.Method))
@@ -164,7 +512,7 @@ func buildEndpointHandle(configuration *config.EndpointConfig, prxy proxy.Proxy)
 			}
 		}
 
-		err = render(w, response)
+		err = render(w, r, response)
 		cancel()
 		return err
 	}
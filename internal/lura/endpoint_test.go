@@ -0,0 +1,101 @@
+package lura
+
+import (
+	"context"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/proxy"
+	"github.com/luraproject/lura/v2/sd"
+	"github.com/stretchr/testify/assert"
+	"github.com/xico42/caddy-lura/internal/httprouter"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestBuildEndpointHandleHead(t *testing.T) {
+	calls := 0
+	p := func(context.Context, *proxy.Request) (*proxy.Response, error) {
+		calls++
+		return &proxy.Response{Data: map[string]interface{}{"id": 1}, IsComplete: true}, nil
+	}
+
+	handle := buildEndpointHandle(&config.EndpointConfig{Method: http.MethodGet, Timeout: time.Second}, p, jsonRender)
+
+	serve := func(method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/users", nil)
+		ctx := context.WithValue(req.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer())
+		ctx = context.WithValue(ctx, caddyhttp.VarsCtxKey, map[string]any{caddyhttp.ClientIPVarKey: "127.0.0.1"})
+		req = req.WithContext(ctx)
+		w := httptest.NewRecorder()
+		handle(w, req, nil)
+		return w
+	}
+
+	w := serve(http.MethodGet)
+	assert.Equal(t, `{"id":1}`, w.Body.String())
+
+	w = serve(http.MethodHead)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Empty(t, w.Body.String(), "the body of HEAD responses is discarded")
+	assert.Equal(t, 2, calls, "HEAD requests go through the GET pipeline")
+
+	w = serve(http.MethodPost)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestAllowHeader(t *testing.T) {
+	assert.Equal(t, "GET, HEAD, OPTIONS, POST", allowHeader(map[string]bool{http.MethodGet: true, http.MethodHead: true, http.MethodPost: true}))
+	assert.Equal(t, "GET, OPTIONS", allowHeader(map[string]bool{http.MethodGet: true}))
+	assert.Equal(t, "DELETE, OPTIONS", allowHeader(map[string]bool{http.MethodDelete: true, http.MethodOptions: true}))
+}
+
+func TestRouteKey(t *testing.T) {
+	constrained := func(constraints map[string]*regexp.Regexp) *config.EndpointConfig {
+		return &config.EndpointConfig{
+			Endpoint:    "/users/:id/:tab",
+			ExtraConfig: config.ExtraConfig{Namespace: EndpointOptions{Constraints: constraints}},
+		}
+	}
+
+	assert.Equal(t, "/users/:id/:tab", routeKey(&config.EndpointConfig{Endpoint: "/users/:id/:tab"}))
+	assert.Equal(t,
+		"/users/:id/:tab id=^[0-9]+$ tab=^[a-z]+$",
+		routeKey(constrained(map[string]*regexp.Regexp{
+			"tab": regexp.MustCompile(`^[a-z]+$`),
+			"id":  regexp.MustCompile(`^[0-9]+$`),
+		})),
+	)
+}
+
+func TestWithCatchAll(t *testing.T) {
+	var got httprouter.Params
+	handle := withCatchAll("rest", func(_ http.ResponseWriter, _ *http.Request, params httprouter.Params) error {
+		got = params
+		return nil
+	})
+
+	for value, expected := range map[string]string{
+		"/":                   "/",
+		"/a/b/":               "/a/b/",
+		"/a b/c?d":            "/a b/c?d",
+		"/a/../../etc/passwd": "/etc/passwd",
+		"//a/./b":             "/a/b",
+	} {
+		handle(nil, nil, httprouter.Params{{Key: "id", Value: ".."}, {Key: "rest", Value: value}})
+		assert.Equal(t, httprouter.Params{{Key: "id", Value: ".."}, {Key: "rest", Value: expected}}, got, value)
+	}
+}
+
+func TestResolveBackendURL(t *testing.T) {
+	replacer := caddy.NewReplacer()
+	replacer.Set("rest", "/a b/c?d")
+
+	u, err := resolveBackendURL(sd.NewRoundRobinLB(sd.FixedSubscriber{"http://backend"}), replacer, "/v1{rest}", "x=1")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://backend/v1/a%20b/c%3Fd?x=1", u)
+}
@@ -0,0 +1,271 @@
+package lura
+
+import (
+	"container/list"
+	"context"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/proxy"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// FallbackPlaceholder holds the names of the backends of the request that were served from their fallback.
+	FallbackPlaceholder = "lura.fallback"
+
+	// FallbackHeaderName is the response header listing the backends that were served from their fallback.
+	FallbackHeaderName = "X-Lura-Fallback"
+
+	defaultFallbackSize = 1000
+)
+
+// fallbackKeySkippedHeaders are set by the gateway itself rather than forwarded from the client, so they
+// are left out of the default key.
+var fallbackKeySkippedHeaders = map[string]struct{}{
+	"X-Forwarded-For":  {},
+	"X-Forwarded-Host": {},
+	"X-Forwarded-Via":  {},
+	"User-Agent":       {},
+}
+
+// FallbackOptions configures the data merged in place of the response of a backend that failed.
+type FallbackOptions struct {
+	// Data is merged when no response of the backend is known.
+	Data map[string]interface{}
+
+	// LastSuccess keeps the last successful response of the backend for each key, to be merged in place
+	// of the failed responses with the same key.
+	LastSuccess bool
+
+	// Size is the maximum number of responses kept, the least recently used being dropped first.
+	// Zero means 1000.
+	Size int
+
+	// Key is resolved with the caddy replacer into the key of the responses kept. If empty, the url
+	// pattern of the backend is resolved, along with the query strings and the forwarded headers of the
+	// request, so that the responses kept for a client are never served to another.
+	Key string
+}
+
+type fallbackCtxKey struct{}
+
+// fallbacks records the backends of a request that were served from their fallback.
+type fallbacks struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+func (f *fallbacks) add(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.names[name] = true
+}
+
+// list returns the names recorded once each, in the order of the backends of the endpoint.
+func (f *fallbacks) list(remotes []*config.Backend) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	names := make([]string, 0, len(f.names))
+	listed := make(map[string]bool, len(f.names))
+	for _, remote := range remotes {
+		if name := backendOptions(remote).Name; f.names[name] && !listed[name] {
+			names = append(names, name)
+			listed[name] = true
+		}
+	}
+	return names
+}
+
+// newFallbackReportMiddleware reports the backends served from their fallback, as the FallbackHeaderName
+// response header and the FallbackPlaceholder placeholder. The backends report their fallbacks with
+// newFallbackMiddleware.
+func newFallbackReportMiddleware(cfg *config.EndpointConfig) proxy.Middleware {
+	withFallback := false
+	for _, remote := range cfg.Backend {
+		withFallback = withFallback || backendOptions(remote).Fallback != nil
+	}
+
+	return func(next ...proxy.Proxy) proxy.Proxy {
+		if !withFallback {
+			return next[0]
+		}
+
+		return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+			f := &fallbacks{names: map[string]bool{}}
+			response, err := next[0](context.WithValue(ctx, fallbackCtxKey{}, f), request)
+
+			names := f.list(cfg.Backend)
+			if len(names) == 0 {
+				return response, err
+			}
+
+			if replacer, ok := ctx.Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+				replacer.Set(FallbackPlaceholder, strings.Join(names, ", "))
+			}
+			if response != nil {
+				if response.Metadata.Headers == nil {
+					response.Metadata.Headers = map[string][]string{}
+				}
+				response.Metadata.Headers[FallbackHeaderName] = []string{strings.Join(names, ", ")}
+			}
+
+			return response, err
+		}
+	}
+}
+
+// newFallbackMiddleware answers with the fallback data of the backend when it fails, flagging the response
+// as incomplete. Successful responses are kept when LastSuccess is set.
+func newFallbackMiddleware(remote *config.Backend, opts FallbackOptions) proxy.Middleware {
+	var store *fallbackStore
+	if opts.LastSuccess {
+		store = newFallbackStore(opts.Size)
+	}
+	name := backendOptions(remote).Name
+
+	return func(next ...proxy.Proxy) proxy.Proxy {
+		return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+			var key string
+			if store != nil {
+				key = fallbackKey(ctx, remote, opts, request)
+			}
+
+			response, err := next[0](ctx, request)
+			if err == nil && response != nil && response.IsComplete {
+				if store != nil {
+					// the data is copied, since lura merges the other responses into it
+					store.put(key, copyData(response.Data))
+				}
+				return response, err
+			}
+
+			var data map[string]interface{}
+			if store != nil {
+				data = store.get(key)
+			}
+			if data == nil {
+				data = opts.Data
+			}
+			if data == nil {
+				return response, err
+			}
+
+			if f, ok := ctx.Value(fallbackCtxKey{}).(*fallbacks); ok {
+				f.add(name)
+			}
+			return &proxy.Response{Data: copyData(data), IsComplete: false}, nil
+		}
+	}
+}
+
+// fallbackKey returns the key of the responses of the backend kept for the request.
+func fallbackKey(ctx context.Context, remote *config.Backend, opts FallbackOptions, request *proxy.Request) string {
+	replacer, ok := ctx.Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		replacer = caddy.NewReplacer()
+	}
+
+	if opts.Key != "" {
+		return replacer.ReplaceAll(opts.Key, "")
+	}
+
+	key := replacer.ReplaceAll(remote.URLPattern, "")
+	if len(request.Query) > 0 {
+		key += "?" + request.Query.Encode()
+	}
+
+	names := make([]string, 0, len(request.Headers))
+	for k := range request.Headers {
+		if _, skip := fallbackKeySkippedHeaders[textproto.CanonicalMIMEHeaderKey(k)]; !skip {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		key += "\n" + textproto.CanonicalMIMEHeaderKey(k) + ": " + strings.Join(request.Headers[k], ", ")
+	}
+	return key
+}
+
+// fallbackStore keeps the last successful responses of a backend, up to a maximum number of keys.
+type fallbackStore struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type fallbackEntry struct {
+	key  string
+	data map[string]interface{}
+}
+
+func newFallbackStore(size int) *fallbackStore {
+	if size <= 0 {
+		size = defaultFallbackSize
+	}
+	return &fallbackStore{size: size, entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+func (s *fallbackStore) put(key string, data map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok {
+		e.Value.(*fallbackEntry).data = data
+		s.lru.MoveToFront(e)
+		return
+	}
+
+	s.entries[key] = s.lru.PushFront(&fallbackEntry{key: key, data: data})
+	if s.lru.Len() > s.size {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*fallbackEntry).key)
+	}
+}
+
+func (s *fallbackStore) get(key string) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	s.lru.MoveToFront(e)
+	return e.Value.(*fallbackEntry).data
+}
+
+// copyData returns a deep copy of the objects and arrays of data.
+func copyData(data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		return nil
+	}
+
+	c := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		c[k] = copyValue(v)
+	}
+	return c
+}
+
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return copyData(v)
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i := range v {
+			c[i] = copyValue(v[i])
+		}
+		return c
+	default:
+		return v
+	}
+}
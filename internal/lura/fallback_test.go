@@ -0,0 +1,126 @@
+package lura
+
+import (
+	"context"
+	"errors"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/proxy"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"testing"
+)
+
+func TestFallbackMiddleware(t *testing.T) {
+	avatars := &config.Backend{URLPattern: "/avatars/{id}", ExtraConfig: config.ExtraConfig{Namespace: BackendOptions{Name: "avatars"}}}
+
+	var backendErr error
+	backend := func(_ context.Context, request *proxy.Request) (*proxy.Response, error) {
+		if backendErr != nil {
+			return nil, backendErr
+		}
+		return &proxy.Response{Data: map[string]interface{}{"url": request.Params["Id"] + ".png"}, IsComplete: true}, nil
+	}
+	call := func(p proxy.Proxy, id string) (*proxy.Response, error) {
+		replacer := caddy.NewReplacer()
+		replacer.Set("id", id)
+		ctx := context.WithValue(context.Background(), caddy.ReplacerCtxKey, replacer)
+		return p(ctx, &proxy.Request{Params: map[string]string{"Id": id}, Query: url.Values{}})
+	}
+
+	p := newFallbackMiddleware(avatars, FallbackOptions{Data: map[string]interface{}{"url": "default.png"}})(backend)
+	response, err := call(p, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"url": "alice.png"}, response.Data)
+
+	backendErr = errors.New("avatars are down")
+	response, err = call(p, "alice")
+	assert.NoError(t, err, "failures are answered with the fallback data")
+	assert.Equal(t, map[string]interface{}{"url": "default.png"}, response.Data)
+	assert.False(t, response.IsComplete)
+
+	backendErr = nil
+	p = newFallbackMiddleware(avatars, FallbackOptions{LastSuccess: true, Data: map[string]interface{}{"url": "default.png"}})(backend)
+	response, _ = call(p, "alice")
+	response.Data["merged"] = true
+
+	backendErr = errors.New("avatars are down")
+	response, err = call(p, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"url": "alice.png"}, response.Data, "the last response kept is isolated from the merges")
+	response, _ = call(p, "bob")
+	assert.Equal(t, map[string]interface{}{"url": "default.png"}, response.Data, "responses are kept by key")
+
+	p = newFallbackMiddleware(avatars, FallbackOptions{LastSuccess: true})(backend)
+	response, err = call(p, "alice")
+	assert.Nil(t, response)
+	assert.EqualError(t, err, "avatars are down", "failures are returned when nothing is kept")
+}
+
+func TestFallbackMiddlewareForwardedHeaders(t *testing.T) {
+	me := &config.Backend{URLPattern: "/me", ExtraConfig: config.ExtraConfig{Namespace: BackendOptions{Name: "me"}}}
+
+	var backendErr error
+	backend := func(_ context.Context, request *proxy.Request) (*proxy.Response, error) {
+		if backendErr != nil {
+			return nil, backendErr
+		}
+		return &proxy.Response{Data: map[string]interface{}{"token": request.Headers["Authorization"][0]}, IsComplete: true}, nil
+	}
+	call := func(p proxy.Proxy, authorization string) (*proxy.Response, error) {
+		ctx := context.WithValue(context.Background(), caddy.ReplacerCtxKey, caddy.NewReplacer())
+		return p(ctx, &proxy.Request{Query: url.Values{}, Headers: map[string][]string{
+			"Authorization":   {authorization},
+			"X-Forwarded-For": {"10.0.0.1"},
+		}})
+	}
+
+	p := newFallbackMiddleware(me, FallbackOptions{LastSuccess: true})(backend)
+	_, _ = call(p, "Bearer alice")
+
+	backendErr = errors.New("me is down")
+	response, err := call(p, "Bearer alice")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"token": "Bearer alice"}, response.Data)
+
+	response, err = call(p, "Bearer bob")
+	assert.Nil(t, response)
+	assert.EqualError(t, err, "me is down", "the responses kept for a user are not served to another")
+}
+
+func TestFallbackReportMiddleware(t *testing.T) {
+	users := &config.Backend{ExtraConfig: config.ExtraConfig{Namespace: BackendOptions{Name: "users"}}}
+	avatars := &config.Backend{ExtraConfig: config.ExtraConfig{Namespace: BackendOptions{Name: "avatars", Fallback: &FallbackOptions{Data: map[string]interface{}{}}}}}
+	roles := &config.Backend{ExtraConfig: config.ExtraConfig{Namespace: BackendOptions{Name: "roles", Fallback: &FallbackOptions{Data: map[string]interface{}{}}}}}
+	cfg := &config.EndpointConfig{Backend: []*config.Backend{users, avatars, roles}}
+
+	merge := func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+		for _, remote := range []*config.Backend{roles, avatars} {
+			p := newFallbackMiddleware(remote, *backendOptions(remote).Fallback)(func(context.Context, *proxy.Request) (*proxy.Response, error) {
+				return nil, errors.New("down")
+			})
+			_, _ = p(ctx, request)
+		}
+		return &proxy.Response{Data: map[string]interface{}{}}, nil
+	}
+
+	replacer := caddy.NewReplacer()
+	ctx := context.WithValue(context.Background(), caddy.ReplacerCtxKey, replacer)
+	response, err := newFallbackReportMiddleware(cfg)(merge)(ctx, &proxy.Request{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"avatars, roles"}, response.Metadata.Headers[FallbackHeaderName])
+	fallback, _ := replacer.Get(FallbackPlaceholder)
+	assert.Equal(t, "avatars, roles", fallback)
+}
+
+func TestFallbackStore(t *testing.T) {
+	s := newFallbackStore(2)
+	s.put("alice", map[string]interface{}{"id": "alice"})
+	s.put("bob", map[string]interface{}{"id": "bob"})
+	s.get("alice")
+	s.put("carol", map[string]interface{}{"id": "carol"})
+
+	assert.Nil(t, s.get("bob"), "the least recently used response is dropped")
+	assert.Equal(t, map[string]interface{}{"id": "alice"}, s.get("alice"))
+	assert.Equal(t, map[string]interface{}{"id": "carol"}, s.get("carol"))
+}
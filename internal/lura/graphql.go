@@ -0,0 +1,183 @@
+package lura
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/luraproject/lura/v2/proxy"
+	"github.com/luraproject/lura/v2/transport/http/client/graphql"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// GraphQLOptions configures a backend that speaks GraphQL over HTTP.
+type GraphQLOptions struct {
+	// Query is the GraphQL query or mutation sent to the backend.
+	Query string
+
+	// OperationName selects the operation to run when Query holds several of them.
+	OperationName string
+
+	// Variables holds the variables of the operation. String values may contain caddy placeholders,
+	// which are replaced on each request, and are sent as strings. A value made of a single placeholder
+	// suffixed with a type, such as "{id:int}", is sent with that type: "int", "float" or "bool".
+	Variables map[string]interface{}
+}
+
+// GraphQLError is returned when a GraphQL backend answers with a non-empty "errors" list.
+type GraphQLError struct {
+	Errors []GraphQLErrorMessage
+}
+
+// GraphQLErrorMessage is a single entry of the GraphQL "errors" list.
+type GraphQLErrorMessage struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+func (e GraphQLError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, m := range e.Errors {
+		messages = append(messages, m.Message)
+	}
+	return "graphql: " + strings.Join(messages, "; ")
+}
+
+// newGraphQLMiddleware turns the request into a GraphQL POST request carrying the configured operation.
+// For requests with a JSON object body, its properties override the configured variables.
+func newGraphQLMiddleware(opts GraphQLOptions) proxy.Middleware {
+	return func(next ...proxy.Proxy) proxy.Proxy {
+		return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+			replacer, ok := ctx.Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+			if !ok {
+				return nil, errors.New("could not find caddy replacer")
+			}
+
+			variables := make(map[string]interface{}, len(opts.Variables))
+			for k, v := range opts.Variables {
+				resolved, err := resolveGraphQLVariable(replacer, v)
+				if err != nil {
+					return nil, fmt.Errorf("graphql: variable %s: %w", k, err)
+				}
+				variables[k] = resolved
+			}
+
+			if request.Body != nil {
+				var bodyVariables map[string]interface{}
+				err := json.NewDecoder(request.Body).Decode(&bodyVariables)
+				request.Body.Close()
+				if err != nil && !errors.Is(err, io.EOF) {
+					return nil, fmt.Errorf("graphql: request body should be a JSON object: %w", err)
+				}
+				for k, v := range bodyVariables {
+					variables[k] = v
+				}
+			}
+
+			body, err := json.Marshal(graphql.GraphQLRequest{
+				Query:         opts.Query,
+				OperationName: opts.OperationName,
+				Variables:     variables,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			request.Method = http.MethodPost
+			request.Body = io.NopCloser(bytes.NewReader(body))
+			request.Headers = proxy.CloneRequestHeaders(request.Headers)
+			request.Headers["Content-Type"] = []string{"application/json"}
+			request.Headers["Content-Length"] = []string{strconv.Itoa(len(body))}
+
+			return next[0](ctx, request)
+		}
+	}
+}
+
+// resolveGraphQLVariable replaces the placeholders of the string values. Values made of a single typed
+// placeholder, such as "{id:int}", are converted to that type, an empty value being sent as null.
+func resolveGraphQLVariable(replacer *caddy.Replacer, v interface{}) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return v, nil
+	}
+
+	placeholder, typ := splitGraphQLVariableType(s)
+	if typ == "" {
+		return replacer.ReplaceKnown(s, ""), nil
+	}
+
+	resolved := replacer.ReplaceAll(placeholder, "")
+	if resolved == "" {
+		return nil, nil
+	}
+
+	switch typ {
+	case "int":
+		i, err := strconv.ParseInt(resolved, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not an int", resolved)
+		}
+		return i, nil
+	case "float":
+		if _, err := strconv.ParseFloat(resolved, 64); err != nil || !json.Valid([]byte(resolved)) {
+			return nil, fmt.Errorf("'%s' is not a float", resolved)
+		}
+		return json.Number(resolved), nil
+	default:
+		b, err := strconv.ParseBool(resolved)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a bool", resolved)
+		}
+		return b, nil
+	}
+}
+
+// splitGraphQLVariableType splits a value made of a single typed placeholder, such as "{id:int}",
+// into the placeholder and its type. The type is empty for any other value.
+func splitGraphQLVariableType(s string) (string, string) {
+	if len(s) <= 2 || s[0] != '{' || s[len(s)-1] != '}' || strings.Count(s, "{") != 1 {
+		return s, ""
+	}
+
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return s, ""
+	}
+
+	switch typ := s[i+1 : len(s)-1]; typ {
+	case "int", "float", "bool":
+		return s[:i] + "}", typ
+	default:
+		return s, ""
+	}
+}
+
+// unwrapGraphQLResponse replaces the GraphQL response envelope by its "data" object,
+// turning a non-empty "errors" list into a GraphQLError.
+func unwrapGraphQLResponse(data map[string]interface{}) (map[string]interface{}, error) {
+	if errs, ok := data["errors"].([]interface{}); ok && len(errs) > 0 {
+		b, err := json.Marshal(errs)
+		if err != nil {
+			return nil, err
+		}
+
+		var graphQLErr GraphQLError
+		if err := json.Unmarshal(b, &graphQLErr.Errors); err != nil {
+			return nil, err
+		}
+		return nil, graphQLErr
+	}
+
+	unwrapped, ok := data["data"].(map[string]interface{})
+	if !ok {
+		return map[string]interface{}{}, nil
+	}
+
+	return unwrapped, nil
+}
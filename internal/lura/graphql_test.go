@@ -0,0 +1,86 @@
+package lura
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/luraproject/lura/v2/proxy"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestResolveGraphQLVariable(t *testing.T) {
+	replacer := caddy.NewReplacer()
+	replacer.Set("id", "00123")
+	replacer.Set("limit", "10")
+	replacer.Set("ratio", "1e5")
+	replacer.Set("active", "true")
+
+	testCases := []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{value: "{id}", expected: "00123"},
+		{value: "{ratio}", expected: "1e5"},
+		{value: "{active}", expected: "true"},
+		{value: "user-{id}", expected: "user-00123"},
+		{value: "{limit:int}", expected: int64(10)},
+		{value: "{ratio:float}", expected: json.Number("1e5")},
+		{value: "{active:bool}", expected: true},
+		{value: "{missing:int}", expected: nil},
+		{value: 10, expected: 10},
+	}
+	for _, tc := range testCases {
+		actual, err := resolveGraphQLVariable(replacer, tc.value)
+		assert.NoError(t, err, tc.value)
+		assert.Equal(t, tc.expected, actual, tc.value)
+	}
+
+	_, err := resolveGraphQLVariable(replacer, "{id:bool}")
+	assert.EqualError(t, err, "'00123' is not a bool")
+}
+
+func TestGraphQLMiddleware(t *testing.T) {
+	var body map[string]interface{}
+	next := func(_ context.Context, request *proxy.Request) (*proxy.Response, error) {
+		assert.Equal(t, http.MethodPost, request.Method)
+		assert.NoError(t, json.NewDecoder(request.Body).Decode(&body))
+		return &proxy.Response{Data: map[string]interface{}{}, IsComplete: true}, nil
+	}
+
+	p := newGraphQLMiddleware(GraphQLOptions{
+		Query:     "query($id: ID!, $limit: Int) { user(id: $id) { id } }",
+		Variables: map[string]interface{}{"id": "{id}", "limit": "{limit:int}", "flag": "{flag}"},
+	})(next)
+
+	replacer := caddy.NewReplacer()
+	replacer.Set("id", "00123")
+	replacer.Set("limit", "5")
+	replacer.Set("flag", "true")
+	ctx := context.WithValue(context.Background(), caddy.ReplacerCtxKey, replacer)
+
+	_, err := p(ctx, &proxy.Request{Method: http.MethodGet, Headers: map[string][]string{}})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": "00123", "limit": 5.0, "flag": "true"}, body["variables"])
+
+	replacer.Set("limit", "five")
+	_, err = p(ctx, &proxy.Request{Method: http.MethodGet, Headers: map[string][]string{}})
+	assert.EqualError(t, err, "graphql: variable limit: 'five' is not an int")
+}
+
+func TestUnwrapGraphQLResponse(t *testing.T) {
+	data, err := unwrapGraphQLResponse(map[string]interface{}{
+		"data": map[string]interface{}{"user": "john"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"user": "john"}, data)
+
+	_, err = unwrapGraphQLResponse(map[string]interface{}{
+		"data": nil,
+		"errors": []interface{}{
+			map[string]interface{}{"message": "user not found", "path": []interface{}{"user"}},
+		},
+	})
+	assert.EqualError(t, err, "graphql: user not found")
+}
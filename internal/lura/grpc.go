@@ -0,0 +1,398 @@
+package lura
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/proxy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	endpointParamsPattern = regexp.MustCompile(`[:*](\w+)`)
+
+	// headers that are either managed by the gRPC transport or meaningless as metadata
+	grpcSkippedHeaders = map[string]struct{}{
+		"connection":        {},
+		"content-length":    {},
+		"content-type":      {},
+		"host":              {},
+		"keep-alive":        {},
+		"te":                {},
+		"trailer":           {},
+		"transfer-encoding": {},
+		"upgrade":           {},
+		"user-agent":        {},
+	}
+)
+
+// GRPCOptions configures a backend that is called through gRPC.
+type GRPCOptions struct {
+	// Method is the descriptor of the unary method invoked on the backend.
+	Method protoreflect.MethodDescriptor
+}
+
+// GRPCError is returned when a gRPC backend answers with a non-OK status.
+type GRPCError struct {
+	Status *status.Status
+}
+
+func (e GRPCError) Error() string {
+	return fmt.Sprintf("grpc: %s: %s", e.Status.Code(), e.Status.Message())
+}
+
+// StatusCode returns the HTTP status matching the gRPC status code.
+func (e GRPCError) StatusCode() int {
+	return httpStatusFromGRPCCode(e.Status.Code())
+}
+
+// NewGRPCMethod finds the descriptor of a method, given as "package.Service/Method", in a protoset,
+// the serialized FileDescriptorSet generated by `protoc --include_imports --descriptor_set_out`.
+func NewGRPCMethod(protoset []byte, method string) (protoreflect.MethodDescriptor, error) {
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(protoset, &set); err != nil {
+		return nil, fmt.Errorf("invalid protoset: %w", err)
+	}
+
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid protoset: %w", err)
+	}
+
+	service, name, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if !ok {
+		return nil, fmt.Errorf("grpc method should be in the format package.Service/Method, but got: '%s'", method)
+	}
+
+	d, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("grpc service '%s' not found in protoset: %w", service, err)
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not a grpc service", service)
+	}
+
+	md := sd.Methods().ByName(protoreflect.Name(name))
+	if md == nil {
+		return nil, fmt.Errorf("grpc method '%s' not found in service '%s'", name, service)
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("grpc method '%s' is a streaming method, only unary methods are supported", method)
+	}
+
+	return md, nil
+}
+
+// newGRPCProxy creates a proxy invoking a unary gRPC method on the host picked by the load balancer.
+// Hosts using the https scheme are reached through TLS, any other host through plaintext HTTP/2.
+//
+// The request message is built from the JSON body, then the query strings forwarded by the endpoint and
+// finally the endpoint path params, each of them overriding the fields set by the previous one. The reply
+// is formatted like any other backend response. Client connections are kept in conns.
+func newGRPCProxy(remote *config.Backend, opts GRPCOptions, conns *grpcConnPool, transforms ...Transformer) proxy.Proxy {
+	fullMethod := fmt.Sprintf("/%s/%s", opts.Method.Parent().FullName(), opts.Method.Name())
+	pathParams := endpointParamsPattern.FindAllStringSubmatch(remote.ParentEndpoint, -1)
+	format := newResponseFormatter(remote, transforms...)
+
+	return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+		replacer, ok := ctx.Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+		if !ok {
+			return nil, errors.New("could not find caddy replacer")
+		}
+
+		in := dynamicpb.NewMessage(opts.Method.Input())
+
+		if request.Body != nil {
+			body, err := io.ReadAll(request.Body)
+			request.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			if len(bytes.TrimSpace(body)) > 0 {
+				if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, in); err != nil {
+					return nil, fmt.Errorf("grpc: invalid request body: %w", err)
+				}
+			}
+		}
+
+		for k, vs := range request.Query {
+			if err := setGRPCField(in, k, vs); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, p := range pathParams {
+			if v, ok := replacer.GetString(p[1]); ok {
+				if err := setGRPCField(in, p[1], []string{v}); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		conn, err := conns.get(request.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		md := metadata.MD{}
+		for k, vs := range request.Headers {
+			k = strings.ToLower(k)
+			if _, skip := grpcSkippedHeaders[k]; !skip {
+				md.Append(k, vs...)
+			}
+		}
+
+		out := dynamicpb.NewMessage(opts.Method.Output())
+		if err := conn.Invoke(metadata.NewOutgoingContext(ctx, md), fullMethod, in, out); err != nil {
+			return nil, GRPCError{Status: status.Convert(err)}
+		}
+
+		b, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(out)
+		if err != nil {
+			return nil, err
+		}
+
+		var data map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(b))
+		decoder.UseNumber()
+		if err := decoder.Decode(&data); err != nil {
+			return nil, err
+		}
+
+		return format(&proxy.Response{Data: data, IsComplete: true})
+	}
+}
+
+// grpcConnPool keeps a client connection per backend host. Hosts using the https scheme are reached with
+// tlsConfig, or the default TLS configuration if nil.
+type grpcConnPool struct {
+	tlsConfig *tls.Config
+
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+func newGRPCConnPool(tlsConfig *tls.Config) *grpcConnPool {
+	return &grpcConnPool{tlsConfig: tlsConfig, conns: map[string]*grpc.ClientConn{}}
+}
+
+// close closes every connection of the pool.
+func (p *grpcConnPool) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
+	for key, conn := range p.conns {
+		if err := conn.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(p.conns, key)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *grpcConnPool) get(u *url.URL) (*grpc.ClientConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := u.Scheme + "://" + u.Host
+	if conn, ok := p.conns[key]; ok {
+		return conn, nil
+	}
+
+	creds := insecure.NewCredentials()
+	if u.Scheme == "https" {
+		tlsConfig := &tls.Config{}
+		if p.tlsConfig != nil {
+			tlsConfig = p.tlsConfig.Clone()
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	conn, err := grpc.NewClient(u.Host, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	p.conns[key] = conn
+
+	return conn, nil
+}
+
+// setGRPCField sets the field found at the given path (field names or JSON names joined by dots) from its
+// string representation. Paths that do not lead to a scalar field of the message are ignored.
+func setGRPCField(msg protoreflect.Message, path string, values []string) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	parts := strings.Split(path, ".")
+	for _, name := range parts[:len(parts)-1] {
+		fd := findGRPCField(msg.Descriptor(), name)
+		if fd == nil || fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return nil
+		}
+		msg = msg.Mutable(fd).Message()
+	}
+
+	fd := findGRPCField(msg.Descriptor(), parts[len(parts)-1])
+	if fd == nil || fd.Message() != nil || fd.IsMap() {
+		return nil
+	}
+
+	if fd.IsList() {
+		list := msg.Mutable(fd).List()
+		for _, v := range values {
+			pv, err := parseGRPCValue(fd, v)
+			if err != nil {
+				return err
+			}
+			list.Append(pv)
+		}
+		return nil
+	}
+
+	pv, err := parseGRPCValue(fd, values[len(values)-1])
+	if err != nil {
+		return err
+	}
+	msg.Set(fd, pv)
+
+	return nil
+}
+
+func findGRPCField(md protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	if fd := md.Fields().ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	return md.Fields().ByJSONName(name)
+}
+
+func parseGRPCValue(fd protoreflect.FieldDescriptor, v string) (protoreflect.Value, error) {
+	invalid := func(err error) (protoreflect.Value, error) {
+		return protoreflect.Value{}, fmt.Errorf("grpc: invalid value '%s' for field '%s': %w", v, fd.Name(), err)
+	}
+
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(v), nil
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfBool(b), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		i, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfInt32(int32(i)), nil
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfInt64(i), nil
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		i, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfUint32(uint32(i)), nil
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		i, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfUint64(i), nil
+	case protoreflect.FloatKind:
+		f, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfFloat32(float32(f)), nil
+	case protoreflect.DoubleKind:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfFloat64(f), nil
+	case protoreflect.BytesKind:
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			b, err = base64.URLEncoding.DecodeString(v)
+		}
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfBytes(b), nil
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(v)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		i, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return invalid(err)
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(i)), nil
+	default:
+		return invalid(errors.New("unsupported field kind " + fd.Kind().String()))
+	}
+}
+
+// httpStatusFromGRPCCode maps gRPC status codes to HTTP statuses, following
+// https://github.com/googleapis/googleapis/blob/master/google/rpc/code.proto
+func httpStatusFromGRPCCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
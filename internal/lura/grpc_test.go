@@ -0,0 +1,199 @@
+package lura
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/proxy"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func testProtoset(t *testing.T) []byte {
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Type:     typ.Enum(),
+			Label:    label.Enum(),
+		}
+	}
+	fields := []*descriptorpb.FieldDescriptorProto{
+		field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL),
+		field("name", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL),
+		field("tags", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_LABEL_REPEATED),
+	}
+
+	set := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{
+			{
+				Name:    proto.String("users.proto"),
+				Package: proto.String("users.v1"),
+				Syntax:  proto.String("proto3"),
+				MessageType: []*descriptorpb.DescriptorProto{
+					{Name: proto.String("GetUserRequest"), Field: fields},
+					{Name: proto.String("User"), Field: fields},
+				},
+				Service: []*descriptorpb.ServiceDescriptorProto{
+					{
+						Name: proto.String("UserService"),
+						Method: []*descriptorpb.MethodDescriptorProto{
+							{
+								Name:       proto.String("GetUser"),
+								InputType:  proto.String(".users.v1.GetUserRequest"),
+								OutputType: proto.String(".users.v1.User"),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	b, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestGRPCProxy(t *testing.T) {
+	method, err := NewGRPCMethod(testProtoset(t), "users.v1.UserService/GetUser")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	// echoes the request message back, failing for unknown users
+	server := grpc.NewServer(grpc.UnknownServiceHandler(func(_ interface{}, stream grpc.ServerStream) error {
+		fullMethod, _ := grpc.MethodFromServerStream(stream)
+		assert.Equal(t, "/users.v1.UserService/GetUser", fullMethod)
+
+		in := dynamicpb.NewMessage(method.Input())
+		if err := stream.RecvMsg(in); err != nil {
+			return err
+		}
+		if in.Get(method.Input().Fields().ByName("id")).Int() == 404 {
+			return status.Error(codes.NotFound, "user not found")
+		}
+
+		out := dynamicpb.NewMessage(method.Output())
+		b, _ := proto.Marshal(in)
+		proto.Unmarshal(b, out)
+		return stream.SendMsg(out)
+	}))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conns := newGRPCConnPool(nil)
+	defer conns.close()
+	p := newGRPCProxy(&config.Backend{ParentEndpoint: "/users/:id"}, GRPCOptions{Method: method}, conns)
+
+	call := func(id string, query url.Values, body string) (*proxy.Response, error) {
+		replacer := caddy.NewReplacer()
+		replacer.Set("id", id)
+		// only the query strings forwarded by the endpoint are set into the message
+		replacer.Set("http.request.uri.query", "name=mallory")
+		ctx := context.WithValue(context.Background(), caddy.ReplacerCtxKey, replacer)
+
+		u, _ := url.Parse("http://" + listener.Addr().String() + "/")
+		return p(ctx, &proxy.Request{
+			Method:  http.MethodPost,
+			URL:     u,
+			Query:   query,
+			Body:    io.NopCloser(strings.NewReader(body)),
+			Headers: map[string][]string{"Authorization": {"Bearer token"}},
+		})
+	}
+
+	response, err := call("42", url.Values{"tags": {"a", "b"}, "unknown": {"1"}}, `{"id": 1, "name": "john"}`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]interface{}{
+			"id":   "42",
+			"name": "john",
+			"tags": []interface{}{"a", "b"},
+		}, response.Data)
+		assert.True(t, response.IsComplete)
+	}
+
+	_, err = call("404", nil, "")
+	var grpcErr GRPCError
+	if assert.ErrorAs(t, err, &grpcErr) {
+		assert.Equal(t, http.StatusNotFound, grpcErr.StatusCode())
+	}
+
+	_, err = call("abc", nil, "")
+	assert.Error(t, err)
+}
+
+func TestGRPCConnPoolTLS(t *testing.T) {
+	method, err := NewGRPCMethod(testProtoset(t), "users.v1.UserService/GetUser")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	// borrows the certificate of a test server, valid for 127.0.0.1
+	certs := httptest.NewTLSServer(http.NotFoundHandler())
+	certs.Close()
+	serverTLS := &tls.Config{Certificates: certs.TLS.Certificates, ClientAuth: tls.RequireAnyClientCert}
+
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(serverTLS)), grpc.UnknownServiceHandler(func(_ interface{}, stream grpc.ServerStream) error {
+		in := dynamicpb.NewMessage(method.Input())
+		if err := stream.RecvMsg(in); err != nil {
+			return err
+		}
+		return stream.SendMsg(dynamicpb.NewMessage(method.Output()))
+	}))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	defer server.Stop()
+
+	call := func(tlsConfig *tls.Config) error {
+		conns := newGRPCConnPool(tlsConfig)
+		defer conns.close()
+
+		ctx := context.WithValue(context.Background(), caddy.ReplacerCtxKey, caddy.NewReplacer())
+		u, _ := url.Parse("https://" + listener.Addr().String() + "/")
+		_, err := newGRPCProxy(&config.Backend{}, GRPCOptions{Method: method}, conns)(ctx, &proxy.Request{URL: u})
+		return err
+	}
+
+	assert.Error(t, call(nil), "the backend certificate is not trusted by default")
+	assert.NoError(t, call(&tls.Config{
+		RootCAs:      certs.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs,
+		Certificates: certs.TLS.Certificates,
+	}))
+}
+
+func TestNewGRPCMethodInvalid(t *testing.T) {
+	protoset := testProtoset(t)
+
+	for _, method := range []string{"users.v1.UserService", "users.v1.Missing/GetUser", "users.v1.UserService/Missing", "users.v1.User/GetUser"} {
+		_, err := NewGRPCMethod(protoset, method)
+		assert.Error(t, err, method)
+	}
+
+	_, err := NewGRPCMethod([]byte("not a protoset"), "users.v1.UserService/GetUser")
+	assert.Error(t, err)
+}
@@ -0,0 +1,219 @@
+package lura
+
+import (
+	"context"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/proxy"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHedgeBudget = 10
+
+	// hedgeLatencySamples is the number of latencies kept to compute the dynamic delay.
+	hedgeLatencySamples = 256
+
+	// hedgeMinSamples is the number of latencies required before hedging with a dynamic delay.
+	hedgeMinSamples = 20
+
+	// hedgeMaxTokens caps the hedges that may be saved up while the backend is healthy.
+	hedgeMaxTokens = 10
+)
+
+// HedgeOptions configures hedged calls: when a backend is slow to answer, the same request is sent to another
+// of its hosts, and the first answer is used.
+type HedgeOptions struct {
+	// Delay is the time waited for an answer before hedging. If zero, the delay is the Percentile of the
+	// latencies observed for the backend, and no call is hedged until enough latencies are known.
+	Delay time.Duration
+
+	// Percentile is the latency percentile used as dynamic delay. If zero, 95 is assumed.
+	Percentile float64
+
+	// Budget is the maximum percentage of calls that are hedged. If zero, 10% is assumed.
+	Budget float64
+}
+
+// hedger holds the state shared by the hedged calls of a backend.
+type hedger struct {
+	opts  HedgeOptions
+	hosts []string
+
+	mu        sync.Mutex
+	tokens    float64
+	latencies []time.Duration
+	next      int
+	delay     time.Duration
+}
+
+// newHedgeMiddleware hedges the GET and HEAD calls to backends with several hosts. The hedged call goes to the
+// host following the one picked by the load balancer. The first successful answer is used and the other call
+// is canceled. Hedging is bounded by a budget: each call earns Budget/100 hedges, and each hedge spends one.
+func newHedgeMiddleware(remote *config.Backend, opts HedgeOptions) proxy.Middleware {
+	if opts.Percentile <= 0 {
+		opts.Percentile = 95
+	}
+	if opts.Budget <= 0 {
+		opts.Budget = defaultHedgeBudget
+	}
+
+	h := &hedger{
+		opts:      opts,
+		hosts:     remote.Host,
+		latencies: make([]time.Duration, 0, hedgeLatencySamples),
+		delay:     opts.Delay,
+	}
+
+	return func(next ...proxy.Proxy) proxy.Proxy {
+		return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+			if len(h.hosts) < 2 || (request.Method != http.MethodGet && request.Method != http.MethodHead) {
+				return next[0](ctx, request)
+			}
+
+			h.earn()
+			return h.call(ctx, next[0], request)
+		}
+	}
+}
+
+type hedgeResult struct {
+	response *proxy.Response
+	err      error
+}
+
+func (h *hedger) call(ctx context.Context, next proxy.Proxy, request *proxy.Request) (*proxy.Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, 2)
+	leg := func(request *proxy.Request) {
+		start := time.Now()
+		response, err := next(ctx, request)
+		if err == nil {
+			h.observe(time.Since(start))
+		}
+		results <- hedgeResult{response: response, err: err}
+	}
+
+	hedged := proxy.CloneRequest(request)
+	go leg(request)
+
+	pending := 1
+	var timer <-chan time.Time
+	if delay, ok := h.currentDelay(); ok {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	for {
+		select {
+		case <-timer:
+			timer = nil
+			if h.spend() {
+				hedged.URL = h.otherHost(hedged.URL)
+				pending++
+				go leg(hedged)
+			}
+		case result := <-results:
+			pending--
+			// the loser, if any, is canceled by the deferred cancel. Calls failing before the delay are not
+			// hedged, failures are left to the retry policy.
+			if result.err == nil || pending == 0 {
+				return result.response, result.err
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// otherHost returns a copy of u pointing to the host following the one of u.
+func (h *hedger) otherHost(u *url.URL) *url.URL {
+	if u == nil {
+		return u
+	}
+
+	current := u.Scheme + "://" + u.Host
+	for i, host := range h.hosts {
+		if host == current {
+			other, err := url.Parse(h.hosts[(i+1)%len(h.hosts)])
+			if err != nil {
+				return u
+			}
+
+			hedged := *u
+			hedged.Scheme = other.Scheme
+			hedged.Host = other.Host
+			return &hedged
+		}
+	}
+
+	return u
+}
+
+func (h *hedger) earn() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.tokens += h.opts.Budget / 100
+	if h.tokens > hedgeMaxTokens {
+		h.tokens = hedgeMaxTokens
+	}
+}
+
+func (h *hedger) spend() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.tokens < 1 {
+		return false
+	}
+	h.tokens--
+	return true
+}
+
+func (h *hedger) currentDelay() (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.delay, h.delay > 0
+}
+
+// observe records the latency of a successful call, updating the dynamic delay.
+func (h *hedger) observe(latency time.Duration) {
+	if h.opts.Delay > 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.latencies) < hedgeLatencySamples {
+		h.latencies = append(h.latencies, latency)
+	} else {
+		h.latencies[h.next] = latency
+		h.next = (h.next + 1) % hedgeLatencySamples
+	}
+
+	if len(h.latencies) < hedgeMinSamples {
+		return
+	}
+
+	sorted := make([]time.Duration, len(h.latencies))
+	copy(sorted, h.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	i := int(float64(len(sorted))*h.opts.Percentile/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	h.delay = sorted[i]
+}
@@ -0,0 +1,104 @@
+package lura
+
+import (
+	"context"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/proxy"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newHedgeTestBackend answers after the latency of the host of the request, recording the canceled calls.
+func newHedgeTestBackend(latencies map[string]time.Duration, calls, canceled *atomic.Int32) proxy.Proxy {
+	return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+		calls.Add(1)
+		select {
+		case <-time.After(latencies[request.URL.Host]):
+			return &proxy.Response{Data: map[string]interface{}{"host": request.URL.Host}, IsComplete: true}, nil
+		case <-ctx.Done():
+			canceled.Add(1)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func newHedgeTestRequest() *proxy.Request {
+	u, _ := url.Parse("http://slow:8080/users/42")
+	return &proxy.Request{Method: http.MethodGet, URL: u}
+}
+
+func TestHedgeMiddleware(t *testing.T) {
+	remote := &config.Backend{Host: []string{"http://slow:8080", "http://fast:8080"}}
+	latencies := map[string]time.Duration{"slow:8080": time.Second, "fast:8080": time.Millisecond}
+
+	var calls, canceled atomic.Int32
+	p := newHedgeMiddleware(remote, HedgeOptions{Delay: 10 * time.Millisecond, Budget: 100})(newHedgeTestBackend(latencies, &calls, &canceled))
+
+	start := time.Now()
+	response, err := p(context.Background(), newHedgeTestRequest())
+	if assert.NoError(t, err) {
+		assert.Equal(t, "fast:8080", response.Data["host"])
+	}
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, int32(2), calls.Load())
+
+	assert.Eventually(t, func() bool { return canceled.Load() == 1 }, time.Second, time.Millisecond, "the loser should be canceled")
+
+	latencies["slow:8080"] = 50 * time.Millisecond
+	calls.Store(0)
+	request := newHedgeTestRequest()
+	request.Method = http.MethodPost
+	_, err = p(context.Background(), request)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), calls.Load(), "only reads are hedged")
+}
+
+func TestHedgeMiddlewareBudget(t *testing.T) {
+	remote := &config.Backend{Host: []string{"http://slow:8080", "http://fast:8080"}}
+	latencies := map[string]time.Duration{"slow:8080": 20 * time.Millisecond, "fast:8080": 20 * time.Millisecond}
+
+	var calls, canceled atomic.Int32
+	p := newHedgeMiddleware(remote, HedgeOptions{Delay: time.Millisecond, Budget: 20})(newHedgeTestBackend(latencies, &calls, &canceled))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := p(context.Background(), newHedgeTestRequest())
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, calls.Load(), int32(12), "at most 20% of the calls are hedged")
+}
+
+func TestHedgeMiddlewareDynamicDelay(t *testing.T) {
+	remote := &config.Backend{Host: []string{"http://slow:8080", "http://fast:8080"}}
+	latencies := map[string]time.Duration{"slow:8080": 5 * time.Millisecond, "fast:8080": 5 * time.Millisecond}
+
+	var calls, canceled atomic.Int32
+	p := newHedgeMiddleware(remote, HedgeOptions{Budget: 100})(newHedgeTestBackend(latencies, &calls, &canceled))
+
+	for i := 0; i < hedgeMinSamples; i++ {
+		_, err := p(context.Background(), newHedgeTestRequest())
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(hedgeMinSamples), calls.Load(), "calls are not hedged until enough latencies are known")
+
+	latencies["slow:8080"] = time.Second
+	calls.Store(0)
+	start := time.Now()
+	response, err := p(context.Background(), newHedgeTestRequest())
+	if assert.NoError(t, err) {
+		assert.Equal(t, "fast:8080", response.Data["host"])
+	}
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, int32(2), calls.Load())
+}
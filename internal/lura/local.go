@@ -0,0 +1,146 @@
+package lura
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/luraproject/lura/v2/transport/http/client"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// maxServerDispatchDepth bounds the number of nested dispatches to Caddy servers made by a single request.
+const maxServerDispatchDepth = 8
+
+// ServerLookup returns the handler of the Caddy HTTP server with the given name.
+type ServerLookup func(name string) (http.Handler, error)
+
+// ServerLoopError is returned when a request goes through more than maxServerDispatchDepth nested dispatches
+// to Caddy servers, most likely because a server routes the requests back to the gateway.
+type ServerLoopError struct {
+	// Server is the name of the server the request was about to be dispatched to.
+	Server string
+}
+
+func (e ServerLoopError) Error() string {
+	return "loop detected while dispatching to caddy server " + e.Server
+}
+
+// StatusCode returns the status of the response.
+func (e ServerLoopError) StatusCode() int {
+	return http.StatusLoopDetected
+}
+
+type serverDispatchDepthCtxKey struct{}
+
+// newUnixSocketDialer dials the Unix socket standing behind the host of the address, if any,
+// and the address itself otherwise.
+func newUnixSocketDialer(sockets map[string]string, dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if len(sockets) == 0 {
+		return dial
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		if path, ok := sockets[host]; ok {
+			return dial(ctx, "unix", path)
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// newServerDispatcher hands the requests made to the hosts standing for Caddy servers directly to the handler
+// chain of the server, without reaching the network. The response is buffered. Requests made to any other host
+// are left to next.
+//
+// Dispatched requests keep the Host of the request received by the gateway, so that the host matchers of
+// the server apply as usual. Requests dispatched again by the server, e.g. because it routes them back to the
+// gateway, fail with ServerLoopError beyond maxServerDispatchDepth nested dispatches.
+func newServerDispatcher(servers map[string]string, lookup ServerLookup, next client.HTTPRequestExecutor) client.HTTPRequestExecutor {
+	if len(servers) == 0 || lookup == nil {
+		return next
+	}
+
+	return func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		name, ok := servers[req.URL.Hostname()]
+		if !ok {
+			return next(ctx, req)
+		}
+
+		depth, _ := ctx.Value(serverDispatchDepthCtxKey{}).(int)
+		if depth >= maxServerDispatchDepth {
+			return nil, ServerLoopError{Server: name}
+		}
+
+		handler, err := lookup(name)
+		if err != nil {
+			return nil, err
+		}
+
+		r := req.Clone(context.WithValue(ctx, serverDispatchDepthCtxKey{}, depth+1))
+		r.URL.Scheme = ""
+		r.URL.Host = ""
+		r.RequestURI = r.URL.RequestURI()
+		r.RemoteAddr = "127.0.0.1:0"
+		if r.Body == nil {
+			r.Body = http.NoBody
+		}
+		if replacer, ok := ctx.Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+			if host, ok := replacer.GetString("http.request.hostport"); ok && host != "" {
+				r.Host = host
+			}
+		}
+
+		w := &bufferedResponseWriter{header: http.Header{}}
+		handler.ServeHTTP(w, r)
+
+		return w.response(req), nil
+	}
+}
+
+// bufferedResponseWriter keeps the response written by a handler in memory.
+type bufferedResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+func (w *bufferedResponseWriter) response(req *http.Request) *http.Response {
+	w.WriteHeader(http.StatusOK)
+
+	header := w.header.Clone()
+	header.Set("Content-Length", strconv.Itoa(w.body.Len()))
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", w.status, http.StatusText(w.status)),
+		StatusCode:    w.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(&w.body),
+		ContentLength: int64(w.body.Len()),
+		Request:       req,
+	}
+}
@@ -0,0 +1,115 @@
+package lura
+
+import (
+	"context"
+	"errors"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/luraproject/lura/v2/transport/http/client"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnixSocketDialer(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "users.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Path)
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	c := newHTTPClient(TransportOptions{}, map[string]string{"unix-socket-0.invalid": socket})
+	resp, err := c.Get("http://unix-socket-0.invalid/users/1")
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "/users/1", string(body))
+	}
+}
+
+func TestServerDispatcher(t *testing.T) {
+	var received *http.Request
+	servers := map[string]http.Handler{
+		"internal": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, `{"body":"`+string(body)+`"}`)
+		}),
+	}
+	lookup := func(name string) (http.Handler, error) {
+		return servers[name], nil
+	}
+
+	networkCalls := 0
+	next := func(context.Context, *http.Request) (*http.Response, error) {
+		networkCalls++
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}
+
+	re := newServerDispatcher(map[string]string{"caddy-server-0.invalid": "internal"}, lookup, next)
+
+	replacer := caddy.NewReplacer()
+	replacer.Set("http.request.hostport", "api.example.com")
+	ctx := context.WithValue(context.Background(), caddy.ReplacerCtxKey, replacer)
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "http://caddy-server-0.invalid/users?page=2", strings.NewReader("payload"))
+	resp, err := re(ctx, req)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `{"body":"payload"}`, string(body))
+	assert.Equal(t, "api.example.com", received.Host, "the host of the gateway request is kept")
+	assert.Equal(t, "/users?page=2", received.RequestURI)
+	assert.Equal(t, 0, networkCalls)
+
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, "http://users:8080/users", nil)
+	_, err = re(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, networkCalls, "other hosts are reached through the network")
+}
+
+func TestServerDispatcherLoop(t *testing.T) {
+	var re client.HTTPRequestExecutor
+	dispatches := 0
+
+	// routes every request back to itself, as a server routing back to the gateway would
+	loop := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dispatches++
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, "http://caddy-server-0.invalid/", nil)
+		resp, err := re(r.Context(), req)
+
+		var loopErr ServerLoopError
+		switch {
+		case errors.As(err, &loopErr):
+			w.WriteHeader(loopErr.StatusCode())
+		case err != nil:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(resp.StatusCode)
+		}
+	})
+	lookup := func(string) (http.Handler, error) {
+		return loop, nil
+	}
+	re = newServerDispatcher(map[string]string{"caddy-server-0.invalid": "internal"}, lookup, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://caddy-server-0.invalid/", nil)
+	resp, err := re(context.Background(), req)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusLoopDetected, resp.StatusCode)
+	}
+	assert.Equal(t, maxServerDispatchDepth, dispatches)
+}
@@ -3,8 +3,6 @@ package lura
 import (
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"github.com/luraproject/lura/v2/config"
-	"github.com/luraproject/lura/v2/proxy"
-	"github.com/luraproject/lura/v2/transport/http/client"
 	"github.com/luraproject/lura/v2/transport/http/server"
 	"github.com/xico42/caddy-lura/internal/httprouter"
 	"go.uber.org/zap"
@@ -18,9 +16,8 @@ const (
 )
 
 var (
-	logPrefix        = "[Service: Caddy Lura] "
-	backendHttpProxy = proxy.CustomHTTPProxyFactory(client.NewHTTPClient)
-	allMethods       = []string{
+	logPrefix  = "[Service: Caddy Lura] "
+	allMethods = []string{
 		http.MethodGet,
 		http.MethodPost,
 		http.MethodPut,
@@ -38,15 +35,62 @@ type Opts struct {
 	ZapLogger     *zap.Logger
 	DebugPattern  string
 	EchoPattern   string
+	Mock          *MockOptions
+
+	// Router configures the router matching the requests with the endpoints. If nil, the defaults of
+	// httprouter.New are used and panics are recovered.
+	Router *RouterOptions
+
+	// Renders holds the custom renders of the handler, selected by the endpoint output encoding.
+	Renders map[string]Render
+
+	// UnixSockets maps the backend hosts standing for Unix sockets to the paths of the sockets.
+	UnixSockets map[string]string
+
+	// Servers maps the backend hosts standing for Caddy servers to the names of the servers,
+	// whose handlers are found with ServerLookup.
+	Servers      map[string]string
+	ServerLookup ServerLookup
+
+	renders   renderRegistry
+	resources *resources
+}
+
+// RouterOptions configures the router matching the requests with the endpoints.
+type RouterOptions struct {
+	RedirectTrailingSlash  bool
+	RedirectFixedPath      bool
+	HandleMethodNotAllowed bool
+	HandleOPTIONS          bool
+
+	// NotFound is the JSON body of the answers to the requests matching no endpoint.
+	// If nil, a plain text body is used.
+	NotFound []byte
+
+	// MethodNotAllowed is the JSON body of the answers to the requests matching an endpoint with another method.
+	// If nil, a plain text body is used.
+	MethodNotAllowed []byte
+
+	// RecoverPanics answers the requests whose handling panics with a 500 status, logging the panic.
+	RecoverPanics bool
+}
+
+// Handler serves the endpoints of a service. It owns the HTTP clients and the backend connections opened on
+// behalf of the service, released by Close, so that several handlers may live in the same process.
+type Handler struct {
+	router    *httprouter.Router
+	resources *resources
 }
 
-func NewHandler(opts Opts) (caddyhttp.Handler, error) {
+func NewHandler(opts Opts) (*Handler, error) {
 	logger := newLogger(opts.ZapLogger)
 
-	luraRouter := httprouter.New()
+	luraRouter := newRouter(opts.Router, opts.ZapLogger)
 
-	proxyFactory := newProxyFactory(logger)
-	_ = proxyFactory
+	opts.renders = newRenderRegistry(opts.Renders)
+	opts.resources = newResources(opts.ServiceConfig, opts.UnixSockets, logger)
+
+	proxyFactory := newProxyFactory(logger, opts)
 
 	if opts.DebugPattern == "" {
 		opts.DebugPattern = defaultDebugPattern
@@ -60,11 +104,67 @@ func NewHandler(opts Opts) (caddyhttp.Handler, error) {
 		opts.EchoPattern = strings.TrimRight(opts.EchoPattern, "/") + "/*any"
 	}
 
-	server.InitHTTPDefaultTransport(opts.ServiceConfig)
-
 	registerEndpoints(luraRouter, proxyFactory, logger, opts)
 
-	return luraRouter, nil
+	return &Handler{router: luraRouter, resources: opts.resources}, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	return h.router.ServeHTTP(w, r)
+}
+
+// Close releases the HTTP clients and the backend connections of the handler.
+func (h *Handler) Close() error {
+	return h.resources.close()
+}
+
+// newRouter creates the router of the handler, configured with opts.
+func newRouter(opts *RouterOptions, logger *zap.Logger) *httprouter.Router {
+	r := httprouter.New()
+	r.PanicHandler = newPanicHandler(logger)
+	if opts == nil {
+		return r
+	}
+
+	r.RedirectTrailingSlash = opts.RedirectTrailingSlash
+	r.RedirectFixedPath = opts.RedirectFixedPath
+	r.HandleMethodNotAllowed = opts.HandleMethodNotAllowed
+	r.HandleOPTIONS = opts.HandleOPTIONS
+	if opts.NotFound != nil {
+		r.NotFound = newJSONResponder(http.StatusNotFound, opts.NotFound)
+	}
+	if opts.MethodNotAllowed != nil {
+		r.MethodNotAllowed = newJSONResponder(http.StatusMethodNotAllowed, opts.MethodNotAllowed)
+	}
+	if !opts.RecoverPanics {
+		r.PanicHandler = nil
+	}
+
+	return r
+}
+
+// newJSONResponder answers every request with the given status and JSON body.
+func newJSONResponder(status int, body []byte) caddyhttp.Handler {
+	return caddyhttp.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) error {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, err := w.Write(body)
+		return err
+	})
+}
+
+// newPanicHandler logs the panics recovered by the router and answers with a 500 status.
+func newPanicHandler(logger *zap.Logger) func(http.ResponseWriter, *http.Request, interface{}) {
+	return func(w http.ResponseWriter, r *http.Request, rcv interface{}) {
+		logger.Error("recovered from panic while serving request",
+			zap.Any("panic", rcv),
+			zap.String("method", r.Method),
+			zap.String("uri", r.RequestURI),
+			zap.Stack("stack"),
+		)
+		w.Header().Set(server.CompleteResponseHeaderName, server.HeaderIncompleteResponseValue)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
 }
 
 func clientIP(r *http.Request) string {
@@ -0,0 +1,51 @@
+package lura
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewRouter(t *testing.T) {
+	r := newRouter(&RouterOptions{
+		HandleMethodNotAllowed: true,
+		NotFound:               []byte(`{"error":"not found"}`),
+		MethodNotAllowed:       []byte(`{"error":"method not allowed"}`),
+		RecoverPanics:          true,
+	}, zap.NewNop())
+	r.HandlerFunc(http.MethodGet, "/users", func(http.ResponseWriter, *http.Request) error {
+		panic("boom")
+	})
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	w := serve(http.MethodGet, "/roles")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, `{"error":"not found"}`, w.Body.String())
+
+	w = serve(http.MethodPost, "/users")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "GET", w.Header().Get("Allow"))
+	assert.Equal(t, `{"error":"method not allowed"}`, w.Body.String())
+
+	w = serve(http.MethodGet, "/users/")
+	assert.Equal(t, http.StatusNotFound, w.Code, "trailing slash redirects are disabled")
+
+	w = serve(http.MethodGet, "/users")
+	assert.Equal(t, http.StatusInternalServerError, w.Code, "panics are recovered")
+
+	assert.Panics(t, func() {
+		r := newRouter(&RouterOptions{}, zap.NewNop())
+		r.HandlerFunc(http.MethodGet, "/users", func(http.ResponseWriter, *http.Request) error {
+			panic("boom")
+		})
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+	})
+}
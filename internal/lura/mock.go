@@ -0,0 +1,206 @@
+package lura
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/luraproject/lura/v2/transport/http/client"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// MockOptions configures the mock mode, where backend calls are answered from fixtures instead of the network.
+type MockOptions struct {
+	// Dir is the directory holding the fixtures.
+	Dir string
+
+	// Record sends backend calls to the network and saves their responses into Dir.
+	Record bool
+}
+
+// fixture is the recorded response of a backend call.
+type fixture struct {
+	StatusCode int                 `json:"status_code"`
+	Headers    map[string][]string `json:"headers,omitempty"`
+
+	// Body holds the response body when it is valid UTF-8, so that fixtures can be edited by hand.
+	Body string `json:"body,omitempty"`
+
+	// BodyBase64 holds any other response body.
+	BodyBase64 []byte `json:"body_base64,omitempty"`
+}
+
+// maxFixturePrefix is the length of the escaped path and query kept in the names of the fixture files, so that
+// they stay readable and below the file name limit of the file systems once the hash is appended.
+const maxFixturePrefix = 128
+
+// fixtures stores the recorded responses of a single backend.
+//
+// Each response lives in its own file, keyed by the backend name, the request method, the resolved path and
+// the request body: <dir>/<backend name>/<method>/<escaped path and query, truncated>-<sha256>.json
+// The hash covers the whole path, query and body, so that requests differing only by their body, such as
+// GraphQL queries, get fixtures of their own.
+type fixtures struct {
+	dir string
+}
+
+func newFixtures(opts MockOptions, backendName string) fixtures {
+	return fixtures{dir: filepath.Join(opts.Dir, url.PathEscape(backendName))}
+}
+
+// path returns the path of the fixture of req. The body of req is read to be hashed, and replaced so that
+// the request can still be sent.
+func (f fixtures) path(req *http.Request) (string, error) {
+	key := req.URL.EscapedPath()
+	if req.URL.RawQuery != "" {
+		key += "?" + req.URL.RawQuery
+	}
+
+	h := sha256.New()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		h.Write(body)
+	}
+
+	prefix := url.PathEscape(key)
+	if len(prefix) > maxFixturePrefix {
+		prefix = prefix[:maxFixturePrefix]
+		// do not cut an escape sequence in the middle
+		if i := strings.LastIndexByte(prefix, '%'); i >= 0 && i >= len(prefix)-2 {
+			prefix = prefix[:i]
+		}
+	}
+
+	return filepath.Join(f.dir, strings.ToUpper(req.Method), prefix+"-"+hex.EncodeToString(h.Sum(nil))+".json"), nil
+}
+
+// replay answers the request with its recorded fixture.
+func (f fixtures) replay(_ context.Context, req *http.Request) (*http.Response, error) {
+	path, err := f.path(req)
+	if err != nil {
+		return nil, fmt.Errorf("mock mode: %w", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("mock mode: no fixture recorded for %s %s (%s)", req.Method, req.URL.String(), path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("mock mode: %w", err)
+	}
+
+	var fx fixture
+	if err := json.Unmarshal(b, &fx); err != nil {
+		return nil, fmt.Errorf("mock mode: invalid fixture %s: %w", path, err)
+	}
+
+	body := fx.BodyBase64
+	if body == nil {
+		body = []byte(fx.Body)
+	}
+
+	header := http.Header(fx.Headers)
+	if header == nil {
+		header = http.Header{}
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", fx.StatusCode, http.StatusText(fx.StatusCode)),
+		StatusCode:    fx.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// record executes the request with next and saves the response as a fixture.
+func (f fixtures) record(next client.HTTPRequestExecutor) client.HTTPRequestExecutor {
+	return func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		// the path is computed first, as it reads the body of the request
+		path, err := f.path(req)
+		if err != nil {
+			return nil, fmt.Errorf("mock mode: %w", err)
+		}
+
+		resp, err := next(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+
+		fx := fixture{
+			StatusCode: resp.StatusCode,
+			Headers:    resp.Header.Clone(),
+		}
+		delete(fx.Headers, "Content-Length")
+		if utf8.Valid(body) {
+			fx.Body = string(body)
+		} else {
+			fx.BodyBase64 = body
+		}
+
+		b, err := json.MarshalIndent(fx, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("mock mode: %w", err)
+		}
+		if err := writeFixture(path, b); err != nil {
+			return nil, fmt.Errorf("mock mode: %w", err)
+		}
+
+		return resp, nil
+	}
+}
+
+// writeFixture writes b into a temporary file renamed to path, so that concurrent recordings of the same
+// fixture never leave it half written.
+func writeFixture(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".fixture-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,93 @@
+package lura
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFixturesRecordAndReplay(t *testing.T) {
+	f := newFixtures(MockOptions{Dir: t.TempDir()}, "mock:8081")
+
+	backend := func(_ context.Context, req *http.Request) (*http.Response, error) {
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 42}`))
+		return w.Result(), nil
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "http://mock:8081/users/42?expand=true", nil)
+
+	_, err := f.replay(context.Background(), req)
+	assert.Error(t, err, "replay should fail before recording")
+
+	recorded, err := f.record(backend)(context.Background(), req)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	recordedBody, _ := io.ReadAll(recorded.Body)
+	assert.Equal(t, `{"id": 42}`, string(recordedBody))
+
+	replayed, err := f.replay(context.Background(), req)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	replayedBody, _ := io.ReadAll(replayed.Body)
+	assert.Equal(t, http.StatusCreated, replayed.StatusCode)
+	assert.Equal(t, "application/json", replayed.Header.Get("Content-Type"))
+	assert.Equal(t, `{"id": 42}`, string(replayedBody))
+
+	_, err = f.replay(context.Background(), httptest.NewRequest(http.MethodGet, "http://mock:8081/users/42?expand=true", nil))
+	assert.Error(t, err, "fixtures are keyed by method")
+}
+
+func TestFixturesKey(t *testing.T) {
+	f := newFixtures(MockOptions{Dir: t.TempDir()}, "graphql")
+
+	backend := func(_ context.Context, req *http.Request) (*http.Response, error) {
+		body, _ := io.ReadAll(req.Body)
+		w := httptest.NewRecorder()
+		w.Write(body)
+		return w.Result(), nil
+	}
+	query := func(body string) *http.Request {
+		return httptest.NewRequest(http.MethodPost, "http://graphql/query", strings.NewReader(body))
+	}
+
+	for _, body := range []string{`{"variables":{"id":1}}`, `{"variables":{"id":2}}`} {
+		recorded, err := f.record(backend)(context.Background(), query(body))
+		if assert.NoError(t, err) {
+			sent, _ := io.ReadAll(recorded.Body)
+			assert.Equal(t, body, string(sent), "the body is still sent once hashed")
+		}
+	}
+	for _, body := range []string{`{"variables":{"id":1}}`, `{"variables":{"id":2}}`} {
+		replayed, err := f.replay(context.Background(), query(body))
+		if assert.NoError(t, err) {
+			b, _ := io.ReadAll(replayed.Body)
+			assert.Equal(t, body, string(b), "fixtures are keyed by body")
+		}
+	}
+
+	long := httptest.NewRequest(http.MethodGet, "http://graphql/search?q="+strings.Repeat("%C3%A9", 200), nil)
+	_, err := f.record(backend)(context.Background(), long)
+	assert.NoError(t, err, "long paths and queries are recorded")
+	path, err := f.path(long)
+	if assert.NoError(t, err) {
+		assert.LessOrEqual(t, len(filepath.Base(path)), 255)
+		_, err = os.Stat(path)
+		assert.NoError(t, err)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	for _, e := range entries {
+		assert.False(t, strings.HasPrefix(e.Name(), ".fixture-"), "temporary files are renamed")
+	}
+}
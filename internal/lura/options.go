@@ -0,0 +1,110 @@
+package lura
+
+import (
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/luraproject/lura/v2/config"
+	"regexp"
+	"time"
+)
+
+// Namespace is the extra config key under which caddy-lura stores its own endpoint and backend settings.
+const Namespace = "github.com/xico42/caddy-lura"
+
+// EndpointOptions holds the caddy-lura specific settings of an endpoint.
+// It is stored in the endpoint extra config under Namespace.
+type EndpointOptions struct {
+	// Transform reshapes the merged response of the endpoint before it is rendered.
+	Transform Transformer
+
+	// Stub marks endpoints without backends, answered with their static data only.
+	Stub bool
+
+	// WebSocket turns the endpoint into a WebSocket endpoint when set.
+	WebSocket *WebSocketOptions
+
+	// Stream turns the endpoint into a Server-Sent Events endpoint when set.
+	Stream *StreamOptions
+
+	// Constraints restricts the path parameters listed to the values fully matched by their expression.
+	// Requests with other values fall through to the other endpoints.
+	Constraints map[string]*regexp.Regexp
+
+	// CatchAll names the final path parameter of the endpoint that matches the rest of the path, if any.
+	CatchAll string
+
+	// Matchers restricts the endpoint to the requests matched by any of the sets, when not empty.
+	// Endpoints with the same method and route are tried in order, the one without matchers last.
+	Matchers caddyhttp.MatcherSets
+
+	// IncompleteStatus is the status of the responses failed because of a required backend. Zero means 502.
+	IncompleteStatus int
+}
+
+// BackendOptions holds the caddy-lura specific settings of a backend.
+// It is stored in the backend extra config under Namespace.
+type BackendOptions struct {
+	// Name identifies the backend, e.g. in mock mode fixtures.
+	Name string
+
+	// Transform reshapes the backend response before allow, mapping and group are applied.
+	Transform Transformer
+
+	// GraphQL turns the backend into a GraphQL backend when set.
+	GraphQL *GraphQLOptions
+
+	// GRPC turns the backend into a gRPC backend when set.
+	GRPC *GRPCOptions
+
+	// PubSub turns the backend into a pub/sub backend when set.
+	PubSub *PubSubOptions
+
+	// Retry retries the failed calls to the backend when set.
+	Retry *RetryOptions
+
+	// Hedge hedges the slow calls to the backend when set.
+	Hedge *HedgeOptions
+
+	// Timeout bounds each call to the backend, on top of the endpoint timeout. Zero means no limit.
+	Timeout time.Duration
+
+	// Transport configures the HTTP transport of the backend when set.
+	Transport *TransportOptions
+
+	// Split splits the traffic of the backend between its variants when set.
+	Split *SplitOptions
+
+	// Shadow turns the backend into a shadow backend, left out of the endpoint response, when set.
+	Shadow *ShadowOptions
+
+	// Required fails the requests of the endpoint when the backend does not answer them successfully.
+	Required bool
+
+	// Fallback provides the data merged in place of the failed responses of the backend when set.
+	Fallback *FallbackOptions
+}
+
+func endpointOptions(cfg *config.EndpointConfig) EndpointOptions {
+	if opts, ok := cfg.ExtraConfig[Namespace].(EndpointOptions); ok {
+		return opts
+	}
+	return EndpointOptions{}
+}
+
+func backendOptions(remote *config.Backend) BackendOptions {
+	if opts, ok := remote.ExtraConfig[Namespace].(BackendOptions); ok {
+		return opts
+	}
+	return BackendOptions{}
+}
+
+// transforms returns the transformations applied to the raw backend document, in order.
+func (o BackendOptions) transforms() []Transformer {
+	transforms := make([]Transformer, 0, 2)
+	if o.GraphQL != nil {
+		transforms = append(transforms, unwrapGraphQLResponse)
+	}
+	if o.Transform != nil {
+		transforms = append(transforms, o.Transform)
+	}
+	return transforms
+}
@@ -0,0 +1,237 @@
+package lura
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/proxy"
+	"gocloud.dev/pubsub"
+	"io"
+	"sync"
+	"time"
+
+	// drivers available through pubsub URLs
+	_ "gocloud.dev/pubsub/kafkapubsub"
+	_ "gocloud.dev/pubsub/mempubsub"
+	_ "gocloud.dev/pubsub/natspubsub"
+	_ "gocloud.dev/pubsub/rabbitpubsub"
+)
+
+const (
+	// pubSubMessageIDKey is the metadata key holding the id generated for published messages.
+	pubSubMessageIDKey = "message_id"
+
+	// pubSubShutdownTimeout bounds the time taken to flush and close a topic or a subscription.
+	pubSubShutdownTimeout = 5 * time.Second
+)
+
+// PubSubOptions configures a backend that publishes to a topic or pulls from a subscription,
+// using Go CDK URLs (https://gocloud.dev/howto/pubsub/).
+type PubSubOptions struct {
+	// Pull selects pulling a message from the subscription on each request, rather than publishing
+	// the request body to the topic.
+	Pull bool
+
+	// Topic is the URL of the topic messages are published to, unless Pull is set.
+	Topic string
+
+	// Subscription is the URL of the subscription messages are pulled from, when Pull is set.
+	Subscription string
+
+	// Metadata holds the metadata of published messages. Values may contain caddy placeholders,
+	// which are replaced on each request.
+	Metadata map[string]string
+}
+
+// newPubSubProxy creates a proxy publishing the request body to the topic, or pulling a single message from
+// the subscription when the options of conn select it, whatever the method of the request.
+// Published messages are answered with their id: the one given by the broker when the driver exposes it,
+// otherwise the id generated for the message and sent in its metadata.
+// Pulled messages are decoded with the backend decoder and acknowledged.
+//
+// The topic and the subscription are opened by conn on first use, and kept until conn is closed.
+func newPubSubProxy(remote *config.Backend, conn *pubSubConn, transforms ...Transformer) proxy.Proxy {
+	format := newResponseFormatter(remote, transforms...)
+
+	return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+		var response *proxy.Response
+		var err error
+		if conn.opts.Pull {
+			response, err = conn.pull(ctx, remote)
+		} else {
+			response, err = conn.publish(ctx, request)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return format(response)
+	}
+}
+
+// pubSubConn lazily opens the topic and the subscription of a backend.
+type pubSubConn struct {
+	opts PubSubOptions
+
+	mu           sync.Mutex
+	topic        *pubsub.Topic
+	subscription *pubsub.Subscription
+}
+
+func newPubSubConn(opts PubSubOptions) *pubSubConn {
+	return &pubSubConn{opts: opts}
+}
+
+// close shuts the topic and the subscription down, flushing the messages not sent yet.
+func (c *pubSubConn) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), pubSubShutdownTimeout)
+	defer cancel()
+
+	var errs []error
+	if c.topic != nil {
+		errs = append(errs, c.topic.Shutdown(ctx))
+		c.topic = nil
+	}
+	if c.subscription != nil {
+		errs = append(errs, c.subscription.Shutdown(ctx))
+		c.subscription = nil
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *pubSubConn) openTopic(ctx context.Context) (*pubsub.Topic, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.topic == nil {
+		if c.opts.Topic == "" {
+			return nil, errors.New("pubsub: no topic to publish to")
+		}
+
+		topic, err := pubsub.OpenTopic(ctx, c.opts.Topic)
+		if err != nil {
+			return nil, fmt.Errorf("pubsub: %w", err)
+		}
+		c.topic = topic
+	}
+
+	return c.topic, nil
+}
+
+func (c *pubSubConn) openSubscription(ctx context.Context) (*pubsub.Subscription, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.subscription == nil {
+		if c.opts.Subscription == "" {
+			return nil, errors.New("pubsub: no subscription to pull from")
+		}
+
+		subscription, err := pubsub.OpenSubscription(ctx, c.opts.Subscription)
+		if err != nil {
+			return nil, fmt.Errorf("pubsub: %w", err)
+		}
+		c.subscription = subscription
+	}
+
+	return c.subscription, nil
+}
+
+func (c *pubSubConn) publish(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+	topic, err := c.openTopic(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	replacer, ok := ctx.Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		return nil, errors.New("could not find caddy replacer")
+	}
+
+	var body []byte
+	if request.Body != nil {
+		body, err = io.ReadAll(request.Body)
+		request.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	id, err := newPubSubMessageID()
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make(map[string]string, len(c.opts.Metadata)+1)
+	for k, v := range c.opts.Metadata {
+		metadata[k] = replacer.ReplaceKnown(v, "")
+	}
+	metadata[pubSubMessageIDKey] = id
+
+	err = topic.Send(ctx, &pubsub.Message{
+		Body:     body,
+		Metadata: metadata,
+		// drivers that know the id given by the broker expose it as a *string
+		AfterSend: func(as func(interface{}) bool) error {
+			var brokerID string
+			if as(&brokerID) && brokerID != "" {
+				id = brokerID
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pubsub: %w", err)
+	}
+
+	return &proxy.Response{
+		Data:       map[string]interface{}{"id": id},
+		IsComplete: true,
+	}, nil
+}
+
+func (c *pubSubConn) pull(ctx context.Context, remote *config.Backend) (*proxy.Response, error) {
+	subscription, err := c.openSubscription(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := subscription.Receive(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("pubsub: %w", err)
+	}
+
+	var data map[string]interface{}
+	if err := remote.Decoder(bytes.NewReader(msg.Body), &data); err != nil {
+		// leave the message to other consumers when possible
+		if msg.Nackable() {
+			msg.Nack()
+		} else {
+			msg.Ack()
+		}
+		return nil, fmt.Errorf("pubsub: decoding message: %w", err)
+	}
+	msg.Ack()
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+
+	return &proxy.Response{Data: data, IsComplete: true}, nil
+}
+
+func newPubSubMessageID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
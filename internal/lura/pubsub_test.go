@@ -0,0 +1,149 @@
+package lura
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/encoding"
+	"github.com/luraproject/lura/v2/proxy"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gocloud.dev/pubsub"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPubSubProxy(t *testing.T) {
+	ctx := context.Background()
+
+	// mem topics must exist before subscriptions are opened
+	topic, err := pubsub.OpenTopic(ctx, "mem://orders")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer topic.Shutdown(ctx)
+
+	subscription, err := pubsub.OpenSubscription(ctx, "mem://orders")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer subscription.Shutdown(ctx)
+
+	publisherConn := newPubSubConn(PubSubOptions{
+		Topic:    "mem://orders",
+		Metadata: map[string]string{"tenant": "{http.request.header.X-Tenant-Id}", "order": "{id}"},
+	})
+	defer publisherConn.close()
+	publisher := newPubSubProxy(&config.Backend{}, publisherConn)
+
+	replacer := caddy.NewReplacer()
+	replacer.Set("id", "42")
+	replacer.Set("http.request.header.X-Tenant-Id", "acme")
+	reqCtx := context.WithValue(ctx, caddy.ReplacerCtxKey, replacer)
+
+	response, err := publisher(reqCtx, &proxy.Request{
+		Method: http.MethodPost,
+		Body:   io.NopCloser(strings.NewReader(`{"id": 42, "total": 10}`)),
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	id, _ := response.Data["id"].(string)
+	assert.NotEmpty(t, id)
+	assert.True(t, response.IsComplete)
+
+	receiveCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	msg, err := subscription.Receive(receiveCtx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	msg.Ack()
+	assert.Equal(t, `{"id": 42, "total": 10}`, string(msg.Body))
+	assert.Equal(t, map[string]string{"tenant": "acme", "order": "42", "message_id": id}, msg.Metadata)
+
+	pullerConn := newPubSubConn(PubSubOptions{Pull: true, Subscription: "mem://orders"})
+	defer pullerConn.close()
+	puller := newPubSubProxy(&config.Backend{Decoder: encoding.JSONDecoder, Group: "order"}, pullerConn)
+
+	// the subscription is opened by the first pull, before the message is published
+	timeoutCtx, cancel := context.WithTimeout(reqCtx, 10*time.Millisecond)
+	defer cancel()
+	_, err = puller(timeoutCtx, &proxy.Request{Method: http.MethodGet})
+	assert.Error(t, err, "pulling should time out while no message is available")
+
+	assert.NoError(t, topic.Send(ctx, &pubsub.Message{Body: []byte(`{"id": 43}`)}))
+
+	// the mode does not depend on the method of the backend request
+	response, err = puller(reqCtx, &proxy.Request{Method: http.MethodPost})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]interface{}{"order": map[string]interface{}{"id": json.Number("43")}}, response.Data)
+	}
+
+	_, err = newPubSubProxy(&config.Backend{}, newPubSubConn(PubSubOptions{Subscription: "mem://orders"}))(reqCtx, &proxy.Request{Method: http.MethodGet})
+	assert.Error(t, err, "publishing requires a topic")
+}
+
+func TestPubSubPullHead(t *testing.T) {
+	ctx := context.Background()
+
+	topic, err := pubsub.OpenTopic(ctx, "mem://head-orders")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer topic.Shutdown(ctx)
+
+	cfg := config.ServiceConfig{
+		Version: config.ConfigVersion,
+		Timeout: time.Second,
+		Endpoints: []*config.EndpointConfig{{
+			Endpoint: "/orders",
+			Method:   http.MethodGet,
+			Backend: []*config.Backend{{
+				Host:        []string{"http://pubsub"},
+				URLPattern:  "/",
+				ExtraConfig: config.ExtraConfig{Namespace: BackendOptions{PubSub: &PubSubOptions{Pull: true, Subscription: "mem://head-orders"}}},
+			}},
+		}},
+	}
+	if !assert.NoError(t, cfg.Init()) {
+		t.FailNow()
+	}
+	h, err := NewHandler(Opts{ServiceConfig: cfg, ZapLogger: zap.NewNop(), Router: &RouterOptions{HandleMethodNotAllowed: true}})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer h.Close()
+
+	serve := func(method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/orders", nil)
+		reqCtx := context.WithValue(req.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer())
+		reqCtx = context.WithValue(reqCtx, caddyhttp.VarsCtxKey, map[string]any{caddyhttp.ClientIPVarKey: "127.0.0.1"})
+		w := httptest.NewRecorder()
+		_ = h.ServeHTTP(w, req.WithContext(reqCtx))
+		return w
+	}
+
+	// the subscription is opened by the first pull, before the message is published
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	reqCtx := context.WithValue(timeoutCtx, caddy.ReplacerCtxKey, caddy.NewReplacer())
+	reqCtx = context.WithValue(reqCtx, caddyhttp.VarsCtxKey, map[string]any{caddyhttp.ClientIPVarKey: "127.0.0.1"})
+	_ = h.ServeHTTP(httptest.NewRecorder(), req.WithContext(reqCtx))
+
+	assert.NoError(t, topic.Send(ctx, &pubsub.Message{Body: []byte(`{"id": 44}`)}))
+
+	w := serve(http.MethodHead)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code, "pulling endpoints do not serve HEAD requests")
+
+	w = serve(http.MethodGet)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"id":44}`, w.Body.String(), "the message is left for the next GET")
+}
@@ -10,7 +10,6 @@ import (
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"io"
 	"net/http"
-	"sync"
 
 	"github.com/luraproject/lura/v2/config"
 	"github.com/luraproject/lura/v2/encoding"
@@ -19,49 +18,47 @@ import (
 
 // Render defines the signature of the functions to be use for the final response
 // encoding and rendering
-type Render func(http.ResponseWriter, *proxy.Response) error
+type Render func(http.ResponseWriter, *http.Request, *proxy.Response) error
 
 // NEGOTIATE defines the value of the OutputEncoding for the negotiated render
 const NEGOTIATE = "negotiate"
 
-var (
-	mutex          = &sync.RWMutex{}
-	renderRegister = map[string]Render{
+// renderRegistry holds the renders of a handler, by output encoding.
+type renderRegistry map[string]Render
+
+// newRenderRegistry creates a registry with the default renders along with the custom ones.
+func newRenderRegistry(custom map[string]Render) renderRegistry {
+	r := renderRegistry{
 		encoding.STRING:   stringRender,
 		encoding.JSON:     jsonRender,
 		encoding.NOOP:     noopRender,
 		"json-collection": jsonCollectionRender,
 	}
-)
-
-// RegisterRender allows clients to register their custom renders
-func RegisterRender(name string, r Render) {
-	mutex.Lock()
-	renderRegister[name] = r
-	mutex.Unlock()
+	for name, render := range custom {
+		r[name] = render
+	}
+	return r
 }
 
-func getRender(cfg *config.EndpointConfig) Render {
+func (r renderRegistry) get(cfg *config.EndpointConfig) Render {
 	fallback := jsonRender
 	if len(cfg.Backend) == 1 {
-		fallback = getWithFallback(cfg.Backend[0].Encoding, fallback)
+		fallback = r.getWithFallback(cfg.Backend[0].Encoding, fallback)
 	}
 
 	if cfg.OutputEncoding == "" {
 		return fallback
 	}
 
-	return getWithFallback(cfg.OutputEncoding, fallback)
+	return r.getWithFallback(cfg.OutputEncoding, fallback)
 }
 
-func getWithFallback(key string, fallback Render) Render {
-	mutex.RLock()
-	r, ok := renderRegister[key]
-	mutex.RUnlock()
+func (r renderRegistry) getWithFallback(key string, fallback Render) Render {
+	render, ok := r[key]
 	if !ok {
 		return fallback
 	}
-	return r
+	return render
 }
 
 var (
@@ -69,7 +66,7 @@ var (
 	emptyCollection = []byte("[]")
 )
 
-func jsonRender(w http.ResponseWriter, response *proxy.Response) error {
+func jsonRender(w http.ResponseWriter, _ *http.Request, response *proxy.Response) error {
 	w.Header().Set("Content-Type", "application/json")
 	if response == nil {
 		w.Write(emptyResponse)
@@ -84,7 +81,7 @@ func jsonRender(w http.ResponseWriter, response *proxy.Response) error {
 	return nil
 }
 
-func jsonCollectionRender(w http.ResponseWriter, response *proxy.Response) error {
+func jsonCollectionRender(w http.ResponseWriter, _ *http.Request, response *proxy.Response) error {
 	w.Header().Set("Content-Type", "application/json")
 	if response == nil {
 		w.Write(emptyCollection)
@@ -104,7 +101,7 @@ func jsonCollectionRender(w http.ResponseWriter, response *proxy.Response) error
 	return nil
 }
 
-func stringRender(w http.ResponseWriter, response *proxy.Response) error {
+func stringRender(w http.ResponseWriter, _ *http.Request, response *proxy.Response) error {
 	w.Header().Set("Content-Type", "text/plain")
 	if response == nil {
 		w.Write([]byte{})
@@ -124,7 +121,7 @@ func stringRender(w http.ResponseWriter, response *proxy.Response) error {
 	return nil
 }
 
-func noopRender(w http.ResponseWriter, response *proxy.Response) error {
+func noopRender(w http.ResponseWriter, _ *http.Request, response *proxy.Response) error {
 	if response == nil {
 		http.Error(w, "", http.StatusInternalServerError)
 		return caddyhttp.Error(http.StatusInternalServerError, errors.New("empty response"))
@@ -0,0 +1,130 @@
+package lura
+
+import (
+	"errors"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/luraproject/lura/v2/transport/http/server"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// resources holds what a handler opens on behalf of its backends, so that it is released along with the handler:
+// the HTTP clients, and the connections of gRPC and pub/sub backends.
+type resources struct {
+	clients *clientPool
+
+	mu      sync.Mutex
+	closers []func() error
+}
+
+func newResources(cfg config.ServiceConfig, sockets map[string]string, logger logging.Logger) *resources {
+	return &resources{clients: newClientPool(cfg, sockets, logger)}
+}
+
+// onClose registers a function called when the resources are released.
+func (r *resources) onClose(f func() error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closers = append(r.closers, f)
+}
+
+func (r *resources) close() error {
+	r.mu.Lock()
+	closers := r.closers
+	r.closers = nil
+	r.mu.Unlock()
+
+	r.clients.close()
+
+	var errs []error
+	for _, f := range closers {
+		if err := f(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// clientPool holds the HTTP clients of a handler: the default one, configured from the service config,
+// and one per backend transport. Backends sharing the same TransportOptions share their client.
+// Connections to the hosts found in sockets are made to the Unix socket they stand for.
+type clientPool struct {
+	defaultClient *http.Client
+	sockets       map[string]string
+
+	mu      sync.Mutex
+	clients map[*TransportOptions]*http.Client
+}
+
+func newClientPool(cfg config.ServiceConfig, sockets map[string]string, logger logging.Logger) *clientPool {
+	return &clientPool{
+		defaultClient: newDefaultHTTPClient(cfg, sockets, logger),
+		sockets:       sockets,
+		clients:       map[*TransportOptions]*http.Client{},
+	}
+}
+
+// get returns the client using the given transport, or the default client if opts is nil.
+func (p *clientPool) get(opts *TransportOptions) *http.Client {
+	if opts == nil {
+		return p.defaultClient
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c, ok := p.clients[opts]
+	if !ok {
+		c = newHTTPClient(*opts, p.sockets)
+		p.clients[opts] = c
+	}
+
+	return c
+}
+
+// close closes the idle connections of every client. Connections in use are closed once released.
+func (p *clientPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.defaultClient.CloseIdleConnections()
+	for _, c := range p.clients {
+		c.CloseIdleConnections()
+	}
+}
+
+// newDefaultHTTPClient creates a client with the transport lura configures from the service config, without
+// replacing the process wide http.DefaultTransport, which is shared with other handlers.
+func newDefaultHTTPClient(cfg config.ServiceConfig, sockets map[string]string, logger logging.Logger) *http.Client {
+	if cfg.AllowInsecureConnections {
+		if cfg.ClientTLS == nil {
+			cfg.ClientTLS = &config.ClientTLS{}
+		}
+		cfg.ClientTLS.AllowInsecureConnections = true
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: newUnixSocketDialer(sockets, (&net.Dialer{
+				Timeout:       cfg.DialerTimeout,
+				KeepAlive:     cfg.DialerKeepAlive,
+				FallbackDelay: cfg.DialerFallbackDelay,
+			}).DialContext),
+			DisableCompression:    cfg.DisableCompression,
+			DisableKeepAlives:     cfg.DisableKeepAlives,
+			MaxIdleConns:          cfg.MaxIdleConns,
+			MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+			IdleConnTimeout:       cfg.IdleConnTimeout,
+			ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+			ExpectContinueTimeout: cfg.ExpectContinueTimeout,
+			TLSHandshakeTimeout:   10 * time.Second,
+			TLSClientConfig:       server.ParseClientTLSConfigWithLogger(cfg.ClientTLS, logger),
+		},
+	}
+}
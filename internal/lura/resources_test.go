@@ -0,0 +1,71 @@
+package lura
+
+import (
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/luraproject/lura/v2/proxy"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientPool(t *testing.T) {
+	var mu sync.Mutex
+	closed := 0
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	backend.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			mu.Lock()
+			closed++
+			mu.Unlock()
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+
+	pool := newClientPool(config.ServiceConfig{}, nil, logging.NoOp)
+	shared := &TransportOptions{}
+
+	assert.Same(t, pool.get(shared), pool.get(shared), "backends sharing a transport share their client")
+	assert.NotSame(t, pool.get(shared), pool.get(&TransportOptions{}))
+	assert.Same(t, pool.get(nil), pool.get(nil))
+	assert.NotSame(t, http.DefaultClient, pool.get(nil), "the default client is not shared with other handlers")
+
+	for _, c := range []*http.Client{pool.get(nil), pool.get(shared)} {
+		resp, err := c.Get(backend.URL)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+	}
+
+	r := &resources{clients: pool}
+	closers := 0
+	r.onClose(func() error { closers++; return nil })
+	assert.NoError(t, r.close())
+	assert.Equal(t, 1, closers)
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return closed == 2
+	}, time.Second, 10*time.Millisecond, "idle connections are closed")
+}
+
+func TestRenderRegistry(t *testing.T) {
+	custom := func(w http.ResponseWriter, _ *http.Request, _ *proxy.Response) error { return nil }
+
+	a := newRenderRegistry(map[string]Render{"template:GET /users": custom})
+	b := newRenderRegistry(nil)
+
+	cfg := &config.EndpointConfig{OutputEncoding: "template:GET /users"}
+	assert.NotNil(t, a["template:GET /users"])
+	assert.Nil(t, b["template:GET /users"], "renders are not shared between registries")
+
+	w := httptest.NewRecorder()
+	assert.NoError(t, b.get(cfg)(w, nil, nil))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"), "unknown encodings fall back to json")
+}
@@ -0,0 +1,196 @@
+package lura
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/luraproject/lura/v2/proxy"
+	"github.com/luraproject/lura/v2/transport/http/client"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryBackoff     = 100 * time.Millisecond
+	defaultRetryMaxBackoff  = 2 * time.Second
+)
+
+// defaultRetryStatuses are the backend status codes retried when none are configured.
+var defaultRetryStatuses = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// idempotentMethods are the methods retried unless RetryOptions.NonIdempotent is set.
+var idempotentMethods = map[string]struct{}{
+	http.MethodGet:     {},
+	http.MethodHead:    {},
+	http.MethodOptions: {},
+	http.MethodTrace:   {},
+	http.MethodPut:     {},
+	http.MethodDelete:  {},
+}
+
+// RetryOptions configures the retries of failed backend calls.
+type RetryOptions struct {
+	// MaxAttempts is the maximum number of calls made to the backend, the first one included.
+	// If zero, 3 attempts are made.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry, doubled on each further retry.
+	// If zero, 100 milliseconds are assumed.
+	Backoff time.Duration
+
+	// MaxBackoff caps the delay between two attempts. If zero, 2 seconds are assumed.
+	MaxBackoff time.Duration
+
+	// Jitter is the fraction of each delay that is randomized, between 0 and 1.
+	Jitter float64
+
+	// Statuses are the backend status codes that are retried. If empty, 502, 503 and 504 are retried.
+	Statuses []int
+
+	// NonIdempotent retries calls made with non-idempotent methods, such as POST and PATCH, as well.
+	NonIdempotent bool
+}
+
+// retryableStatusError is returned for backend responses with a retryable status code.
+// It wraps client.ErrInvalidStatusCode, so that exhausted retries fail like any other invalid status.
+type retryableStatusError struct {
+	code int
+}
+
+func (e retryableStatusError) Error() string {
+	return fmt.Sprintf("%s: %d", client.ErrInvalidStatusCode, e.code)
+}
+
+func (e retryableStatusError) Unwrap() error {
+	return client.ErrInvalidStatusCode
+}
+
+func (o RetryOptions) statuses() []int {
+	if len(o.Statuses) == 0 {
+		return defaultRetryStatuses
+	}
+	return o.Statuses
+}
+
+func (o RetryOptions) retryableStatus(code int) bool {
+	for _, s := range o.statuses() {
+		if s == code {
+			return true
+		}
+	}
+	return false
+}
+
+// newRetryStatusHandler reports the retryable status codes as retryableStatusError, leaving any other response to next.
+func newRetryStatusHandler(opts RetryOptions, next client.HTTPStatusHandler) client.HTTPStatusHandler {
+	return func(ctx context.Context, resp *http.Response) (*http.Response, error) {
+		if opts.retryableStatus(resp.StatusCode) {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			return nil, retryableStatusError{code: resp.StatusCode}
+		}
+		return next(ctx, resp)
+	}
+}
+
+// newRetryMiddleware retries the calls failing with a network error or a retryable status code,
+// waiting for an exponential backoff with jitter between attempts. The request body is buffered so that
+// it may be replayed. Retries stop as soon as the next attempt would start after the request deadline.
+func newRetryMiddleware(opts RetryOptions) proxy.Middleware {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = defaultRetryBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaultRetryMaxBackoff
+	}
+
+	return func(next ...proxy.Proxy) proxy.Proxy {
+		return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+			if _, ok := idempotentMethods[request.Method]; !ok && !opts.NonIdempotent {
+				return next[0](ctx, request)
+			}
+
+			backoff := opts.Backoff
+			for attempt := 1; ; attempt++ {
+				response, err := next[0](ctx, proxy.CloneRequest(request))
+				if err == nil || attempt >= opts.MaxAttempts || ctx.Err() != nil || !opts.retryable(err) {
+					return response, err
+				}
+
+				delay := opts.jittered(backoff)
+				if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+					return response, err
+				}
+
+				timer := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return response, err
+				case <-timer.C:
+				}
+
+				backoff *= 2
+				if backoff > opts.MaxBackoff {
+					backoff = opts.MaxBackoff
+				}
+			}
+		}
+	}
+}
+
+func (o RetryOptions) retryable(err error) bool {
+	// the request deadline is checked beforehand, so this is the backend timeout of the attempt
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var statusErr retryableStatusError
+	if errors.As(err, &statusErr) {
+		return true
+	}
+
+	var withStatus errorWithStatusCode
+	if errors.As(err, &withStatus) {
+		return o.retryableStatus(withStatus.StatusCode())
+	}
+
+	return isNetworkError(err)
+}
+
+func (o RetryOptions) jittered(d time.Duration) time.Duration {
+	if o.Jitter <= 0 {
+		return d
+	}
+
+	jitter := o.Jitter
+	if jitter > 1 {
+		jitter = 1
+	}
+
+	// spreads the delay over [d*(1-jitter), d]
+	return d - time.Duration(rand.Float64()*jitter*float64(d))
+}
+
+// isNetworkError tells whether err comes from a connection failure rather than from the backend answer.
+func isNetworkError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
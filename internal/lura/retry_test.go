@@ -0,0 +1,103 @@
+package lura
+
+import (
+	"context"
+	"errors"
+	"github.com/luraproject/lura/v2/proxy"
+	"github.com/luraproject/lura/v2/transport/http/client"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryMiddleware(t *testing.T) {
+	var bodies []string
+	failures := 0
+
+	// fails with the given error until no failures are left, recording the request bodies
+	backend := func(err error) proxy.Proxy {
+		return func(_ context.Context, request *proxy.Request) (*proxy.Response, error) {
+			b, _ := io.ReadAll(request.Body)
+			bodies = append(bodies, string(b))
+			if failures > 0 {
+				failures--
+				return nil, err
+			}
+			return &proxy.Response{Data: map[string]interface{}{"ok": true}, IsComplete: true}, nil
+		}
+	}
+
+	call := func(p proxy.Proxy, ctx context.Context, method string) (*proxy.Response, error) {
+		bodies = nil
+		return p(ctx, &proxy.Request{Method: method, Body: io.NopCloser(strings.NewReader("payload"))})
+	}
+
+	retry := newRetryMiddleware(RetryOptions{MaxAttempts: 3, Backoff: time.Millisecond, Jitter: 0.5})
+
+	failures = 2
+	response, err := call(retry(backend(syscall.ECONNRESET)), context.Background(), http.MethodGet)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"ok": true}, response.Data)
+	assert.Equal(t, []string{"payload", "payload", "payload"}, bodies, "bodies are replayed on each attempt")
+
+	failures = 3
+	_, err = call(retry(backend(retryableStatusError{code: http.StatusServiceUnavailable})), context.Background(), http.MethodGet)
+	assert.ErrorIs(t, err, client.ErrInvalidStatusCode)
+	assert.Len(t, bodies, 3, "attempts are limited")
+
+	failures = 1
+	_, err = call(retry(backend(GRPCError{})), context.Background(), http.MethodGet)
+	assert.Error(t, err)
+	assert.Len(t, bodies, 1, "non retryable errors are not retried")
+
+	failures = 1
+	_, err = call(retry(backend(syscall.ECONNRESET)), context.Background(), http.MethodPost)
+	assert.Error(t, err)
+	assert.Len(t, bodies, 1, "non idempotent methods are not retried by default")
+
+	failures = 1
+	_, err = call(newRetryMiddleware(RetryOptions{NonIdempotent: true, Backoff: time.Millisecond})(backend(syscall.ECONNRESET)), context.Background(), http.MethodPost)
+	assert.NoError(t, err)
+	assert.Len(t, bodies, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	failures = 3
+	_, err = call(newRetryMiddleware(RetryOptions{Backoff: time.Second})(backend(errors.New("unexpected EOF: "+io.ErrUnexpectedEOF.Error()))), ctx, http.MethodGet)
+	assert.Error(t, err)
+	assert.Len(t, bodies, 1, "plain errors are not network errors")
+
+	failures = 3
+	start := time.Now()
+	_, err = call(newRetryMiddleware(RetryOptions{Backoff: time.Second})(backend(io.ErrUnexpectedEOF)), ctx, http.MethodGet)
+	assert.Error(t, err)
+	assert.Len(t, bodies, 1, "retries do not go past the deadline")
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestRetryStatusHandler(t *testing.T) {
+	handler := newRetryStatusHandler(RetryOptions{Statuses: []int{http.StatusTooManyRequests}}, client.DefaultHTTPStatusHandler)
+
+	w := httptest.NewRecorder()
+	w.WriteHeader(http.StatusTooManyRequests)
+	_, err := handler(context.Background(), w.Result())
+	var statusErr retryableStatusError
+	if assert.ErrorAs(t, err, &statusErr) {
+		assert.Equal(t, http.StatusTooManyRequests, statusErr.code)
+	}
+
+	w = httptest.NewRecorder()
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_, err = handler(context.Background(), w.Result())
+	assert.Equal(t, client.ErrInvalidStatusCode, err, "other statuses are left to the backend status handler")
+
+	w = httptest.NewRecorder()
+	resp, err := handler(context.Background(), w.Result())
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,119 @@
+package lura
+
+import (
+	"fmt"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/xico42/caddy-lura/internal/httprouter"
+	"net/http"
+	"regexp"
+)
+
+// route gathers the endpoints served with the same method and route, told apart by their matchers.
+type route struct {
+	method      string
+	path        string
+	constraints map[string]*regexp.Regexp
+	key         string
+
+	// endpoints holds the endpoints with matchers in the order they were added, followed by the endpoint
+	// without matchers, if any.
+	endpoints []routeEndpoint
+}
+
+type routeEndpoint struct {
+	matchers caddyhttp.MatcherSets
+	handle   httprouter.Handle
+}
+
+// match returns the handle of the first endpoint of the route matching the request, or nil if none does.
+func (r *route) match(req *http.Request) httprouter.Handle {
+	for _, e := range r.endpoints {
+		if len(e.matchers) == 0 || e.matchers.AnyMatch(req) {
+			return e.handle
+		}
+	}
+	return nil
+}
+
+// routeTable collects the endpoint handles before they are registered with the router, so that the endpoints
+// sharing a route are registered as a single handle.
+type routeTable struct {
+	routes []*route
+	byKey  map[string]*route
+}
+
+func newRouteTable() *routeTable {
+	return &routeTable{byKey: map[string]*route{}}
+}
+
+// add adds the handle of an endpoint to the route identified by method and key, served at path.
+// It fails if the route already has an endpoint without matchers and the endpoint has none either.
+func (t *routeTable) add(method, path string, constraints map[string]*regexp.Regexp, key string, matchers caddyhttp.MatcherSets, handle httprouter.Handle) error {
+	r, ok := t.byKey[method+" "+key]
+	if !ok {
+		r = &route{method: method, path: path, constraints: constraints, key: key}
+		t.routes = append(t.routes, r)
+		t.byKey[method+" "+key] = r
+	}
+
+	n := len(r.endpoints)
+	hasFallback := n > 0 && len(r.endpoints[n-1].matchers) == 0
+	e := routeEndpoint{matchers: matchers, handle: handle}
+
+	switch {
+	case len(matchers) > 0 && hasFallback:
+		r.endpoints = append(r.endpoints[:n-1], e, r.endpoints[n-1])
+	case len(matchers) > 0 || !hasFallback:
+		r.endpoints = append(r.endpoints, e)
+	default:
+		return fmt.Errorf("an endpoint without matchers is already registered for %s %s", method, path)
+	}
+
+	return nil
+}
+
+// register registers the routes with the router.
+func (t *routeTable) register(router *httprouter.Router) {
+	for _, r := range t.routes {
+		router.HandleConstrained(r.method, r.path, r.constraints, t.handle(r, router))
+	}
+}
+
+// handle returns the handle serving the requests of the route with its first matching endpoint.
+// Requests matched by none of them are answered as the router answers the requests it cannot route:
+// with 405 if the route of another method matches and the router handles it, and with 404 otherwise.
+func (t *routeTable) handle(r *route, router *httprouter.Router) httprouter.Handle {
+	if len(r.endpoints) == 1 && len(r.endpoints[0].matchers) == 0 {
+		return r.endpoints[0].handle
+	}
+
+	return func(w http.ResponseWriter, req *http.Request, params httprouter.Params) error {
+		if handle := r.match(req); handle != nil {
+			return handle(w, req, params)
+		}
+
+		if router.HandleMethodNotAllowed {
+			methods := map[string]bool{}
+			for _, other := range t.routes {
+				if other != r && other.key == r.key && other.match(req) != nil {
+					methods[other.method] = true
+				}
+			}
+
+			if len(methods) > 0 {
+				w.Header().Set("Allow", allowHeader(methods))
+				if router.MethodNotAllowed != nil {
+					return router.MethodNotAllowed.ServeHTTP(w, req)
+				}
+				http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+				return nil
+			}
+		}
+
+		if router.NotFound != nil {
+			return router.NotFound.ServeHTTP(w, req)
+		}
+		http.NotFound(w, req)
+		return nil
+	}
+}
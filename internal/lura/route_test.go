@@ -0,0 +1,60 @@
+package lura
+
+import (
+	"context"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/xico42/caddy-lura/internal/httprouter"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouteTable(t *testing.T) {
+	respond := func(body string) httprouter.Handle {
+		return func(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) error {
+			w.Write([]byte(body))
+			return nil
+		}
+	}
+	version := func(v string) caddyhttp.MatcherSets {
+		return caddyhttp.MatcherSets{{caddyhttp.MatchHeader{"Accept-Version": {v}}}}
+	}
+
+	routes := newRouteTable()
+	assert.NoError(t, routes.add(http.MethodGet, "/users/:id", nil, "/users/:id", nil, respond("v1")))
+	assert.NoError(t, routes.add(http.MethodGet, "/users/:id", nil, "/users/:id", version("v2"), respond("v2")))
+	assert.NoError(t, routes.add(http.MethodGet, "/users/:id", nil, "/users/:id", version("v3"), respond("v3")))
+	assert.NoError(t, routes.add(http.MethodHead, "/users/:id", nil, "/users/:id", version("v3"), respond("v3")))
+	assert.Error(t, routes.add(http.MethodGet, "/users/:id", nil, "/users/:id", nil, respond("v1")),
+		"only one endpoint of a route may go without matchers")
+	assert.NoError(t, routes.add(http.MethodPut, "/users/:id", nil, "/users/:id", version("v2"), respond("put v2")))
+	assert.NoError(t, routes.add(http.MethodDelete, "/orders/:id", nil, "/orders/:id", version("v2"), respond("delete v2")))
+
+	router := httprouter.New()
+	routes.register(router)
+
+	serve := func(method, path, version string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer()))
+		if version != "" {
+			req.Header.Set("Accept-Version", version)
+		}
+		w := httptest.NewRecorder()
+		assert.NoError(t, router.ServeHTTP(w, req))
+		return w
+	}
+
+	assert.Equal(t, "v1", serve(http.MethodGet, "/users/1", "").Body.String())
+	assert.Equal(t, "v2", serve(http.MethodGet, "/users/1", "v2").Body.String())
+	assert.Equal(t, "v3", serve(http.MethodGet, "/users/1", "v3").Body.String())
+	assert.Equal(t, "put v2", serve(http.MethodPut, "/users/1", "v2").Body.String())
+
+	w := serve(http.MethodPut, "/users/1", "v3")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code, "the GET endpoints still match the request")
+	assert.Equal(t, "GET, HEAD, OPTIONS", w.Header().Get("Allow"))
+
+	assert.Equal(t, "delete v2", serve(http.MethodDelete, "/orders/1", "v2").Body.String())
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodDelete, "/orders/1", "v3").Code)
+}
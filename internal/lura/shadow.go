@@ -0,0 +1,226 @@
+package lura
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/luraproject/lura/v2/proxy"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ShadowOptions configures a shadow backend: it is sent a copy of the requests of its endpoint, but its
+// responses are never merged into the endpoint response.
+type ShadowOptions struct {
+	// SampleRate is the percentage of the requests copied to the backend.
+	SampleRate float64
+
+	// Timeout bounds the calls to the backend, independently of the request. Zero means the endpoint timeout.
+	Timeout time.Duration
+
+	// LogDiff logs the differences between the responses of the backend and those of the Primary backend.
+	LogDiff bool
+
+	// Primary is the name of the backend whose responses are compared with those of the backend, as they are
+	// before being merged with the other backends and transformed.
+	Primary string
+}
+
+// shadow is the proxy stack of a shadow backend.
+type shadow struct {
+	name  string
+	opts  ShadowOptions
+	proxy proxy.Proxy
+}
+
+// splitShadows returns a copy of the endpoint configuration without its shadow backends, along with them.
+func splitShadows(cfg *config.EndpointConfig) (*config.EndpointConfig, []*config.Backend) {
+	primary := *cfg
+	primary.Backend = make([]*config.Backend, 0, len(cfg.Backend))
+	shadows := make([]*config.Backend, 0)
+	for _, remote := range cfg.Backend {
+		if backendOptions(remote).Shadow != nil {
+			shadows = append(shadows, remote)
+		} else {
+			primary.Backend = append(primary.Backend, remote)
+		}
+	}
+	return &primary, shadows
+}
+
+// newShadows builds the proxy stacks of the shadow backends of the endpoint.
+func newShadows(factory proxy.Factory, cfg *config.EndpointConfig, remotes []*config.Backend) ([]shadow, error) {
+	shadows := make([]shadow, 0, len(remotes))
+	for _, remote := range remotes {
+		p, err := factory.New(&config.EndpointConfig{
+			Endpoint: cfg.Endpoint,
+			Method:   cfg.Method,
+			Timeout:  cfg.Timeout,
+			Backend:  []*config.Backend{remote},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		opts := backendOptions(remote)
+		s := shadow{name: opts.Name, opts: *opts.Shadow, proxy: p}
+		if s.opts.Timeout <= 0 {
+			s.opts.Timeout = cfg.Timeout
+		}
+		shadows = append(shadows, s)
+	}
+	return shadows, nil
+}
+
+// newShadowMiddleware sends a sample of the requests to the shadow backends as well, in the background.
+// The shadow calls are detached from the request: they neither delay nor cancel it, and their responses
+// are only used to log their differences with the responses of their primary backend, which are captured
+// by newShadowCaptureMiddleware.
+func newShadowMiddleware(logger logging.Logger, shadows ...shadow) proxy.Middleware {
+	return func(next ...proxy.Proxy) proxy.Proxy {
+		if len(shadows) == 0 {
+			return next[0]
+		}
+
+		return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+			captures := &shadowCaptures{wanted: map[string]bool{}, data: map[string]map[string]interface{}{}}
+			compared := make([]shadow, 0, len(shadows))
+			primaries := make([]chan map[string]interface{}, 0, len(shadows))
+			for _, s := range shadows {
+				if rand.Float64()*100 >= s.opts.SampleRate {
+					continue
+				}
+
+				var primary chan map[string]interface{}
+				if s.opts.LogDiff {
+					primary = make(chan map[string]interface{}, 1)
+					primaries = append(primaries, primary)
+					compared = append(compared, s)
+					captures.wanted[s.opts.Primary] = true
+				}
+				go s.call(context.WithoutCancel(ctx), proxy.CloneRequest(request), primary, logger)
+			}
+
+			if len(compared) > 0 {
+				ctx = context.WithValue(ctx, shadowCtxKey{}, captures)
+			}
+			response, err := next[0](ctx, request)
+
+			for i, primary := range primaries {
+				primary <- captures.get(compared[i].opts.Primary)
+			}
+
+			return response, err
+		}
+	}
+}
+
+type shadowCtxKey struct{}
+
+// shadowCaptures keeps the responses of the primary backends of a request, for the shadow backends comparing
+// their responses with them.
+type shadowCaptures struct {
+	mu     sync.Mutex
+	wanted map[string]bool
+	data   map[string]map[string]interface{}
+}
+
+func (c *shadowCaptures) capture(name string, data map[string]interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.wanted[name] && c.data[name] == nil {
+		// the data is copied, since lura merges the other responses into it
+		c.data[name] = copyData(data)
+	}
+}
+
+func (c *shadowCaptures) get(name string) map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.data[name]
+}
+
+// newShadowCaptureMiddleware captures the complete responses of the backend for the shadow backends comparing
+// their responses with it, before they are merged with the other backends. See newShadowMiddleware.
+func newShadowCaptureMiddleware(remote *config.Backend) proxy.Middleware {
+	name := backendOptions(remote).Name
+
+	return func(next ...proxy.Proxy) proxy.Proxy {
+		return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+			response, err := next[0](ctx, request)
+			if c, ok := ctx.Value(shadowCtxKey{}).(*shadowCaptures); ok && err == nil && response != nil && response.IsComplete {
+				c.capture(name, response.Data)
+			}
+			return response, err
+		}
+	}
+}
+
+// call sends the request to the shadow backend. When primary is not nil, the response is compared with
+// the response of the primary backend received from it, if any.
+func (s shadow) call(ctx context.Context, request *proxy.Request, primary <-chan map[string]interface{}, logger logging.Logger) {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.Timeout)
+	defer cancel()
+
+	response, err := s.proxy(ctx, request)
+	if err != nil {
+		logger.Debug(logPrefix, "shadow backend "+s.name+" failed: "+err.Error())
+	}
+	if primary == nil {
+		return
+	}
+
+	data := <-primary
+	if data == nil || err != nil || response == nil {
+		return
+	}
+
+	if diff := diffData(data, response.Data); len(diff) > 0 {
+		logger.Info(logPrefix, "shadow backend "+s.name+" differs from "+s.opts.Primary+": "+strings.Join(diff, ", "))
+	}
+}
+
+// diffData lists the properties of expected and actual that differ, as "changed <path>", "missing <path>"
+// for the properties of expected only, and "unexpected <path>" for those of actual only.
+func diffData(expected, actual map[string]interface{}) []string {
+	diff := make([]string, 0)
+	diffObjects("", expected, actual, &diff)
+	sort.Strings(diff)
+	return diff
+}
+
+func diffObjects(prefix string, expected, actual map[string]interface{}, diff *[]string) {
+	for k, e := range expected {
+		a, ok := actual[k]
+		if !ok {
+			*diff = append(*diff, "missing "+prefix+k)
+			continue
+		}
+
+		eObject, eIsObject := e.(map[string]interface{})
+		aObject, aIsObject := a.(map[string]interface{})
+		if eIsObject && aIsObject {
+			diffObjects(prefix+k+".", eObject, aObject, diff)
+			continue
+		}
+
+		// values are compared as JSON so that numbers decoded differently are equal
+		eJSON, _ := json.Marshal(e)
+		aJSON, _ := json.Marshal(a)
+		if string(eJSON) != string(aJSON) {
+			*diff = append(*diff, "changed "+prefix+k)
+		}
+	}
+
+	for k := range actual {
+		if _, ok := expected[k]; !ok {
+			*diff = append(*diff, "unexpected "+prefix+k)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package lura
+
+import (
+	"context"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/luraproject/lura/v2/proxy"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"testing"
+	"time"
+)
+
+func TestSplitShadows(t *testing.T) {
+	users := &config.Backend{URLPattern: "/users"}
+	next := &config.Backend{URLPattern: "/next/users", ExtraConfig: config.ExtraConfig{Namespace: BackendOptions{Shadow: &ShadowOptions{}}}}
+	cfg := &config.EndpointConfig{Endpoint: "/users", Backend: []*config.Backend{users, next}}
+
+	primary, shadows := splitShadows(cfg)
+	assert.Equal(t, []*config.Backend{users}, primary.Backend)
+	assert.Equal(t, []*config.Backend{next}, shadows)
+	assert.Len(t, cfg.Backend, 2, "the endpoint configuration is left untouched")
+}
+
+func TestShadowMiddleware(t *testing.T) {
+	release := make(chan struct{})
+	called := make(chan *proxy.Request, 1)
+	canceled := make(chan error, 1)
+	shadowProxy := func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+		called <- request
+		<-release
+		canceled <- ctx.Err()
+		return &proxy.Response{Data: map[string]interface{}{"id": 1}, IsComplete: true}, nil
+	}
+	primary := func(context.Context, *proxy.Request) (*proxy.Response, error) {
+		return &proxy.Response{Data: map[string]interface{}{"id": 1}, IsComplete: true}, nil
+	}
+
+	s := shadow{name: "next", opts: ShadowOptions{SampleRate: 100, Timeout: time.Second}, proxy: shadowProxy}
+	p := newShadowMiddleware(logging.NoOp, s)(primary)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	response, err := p(ctx, &proxy.Request{Path: "/users"})
+	cancel()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": 1}, response.Data, "the shadow response is not merged")
+
+	select {
+	case request := <-called:
+		assert.Equal(t, "/users", request.Path)
+	case <-time.After(time.Second):
+		t.Fatal("the shadow backend was not called")
+	}
+	close(release)
+	assert.NoError(t, <-canceled, "shadow calls outlive the request")
+
+	s.opts.SampleRate = 0
+	p = newShadowMiddleware(logging.NoOp, s)(primary)
+	_, _ = p(context.Background(), &proxy.Request{Path: "/users"})
+	select {
+	case <-called:
+		t.Error("requests out of the sample are not copied")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestShadowMiddlewareLogDiff(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	logged := func(message string) func() bool {
+		return func() bool {
+			return logs.FilterMessage(logPrefix+message).Len() > 0
+		}
+	}
+
+	users := &config.Backend{ExtraConfig: config.ExtraConfig{Namespace: BackendOptions{Name: "users"}}}
+	usersProxy := newShadowCaptureMiddleware(users)(func(context.Context, *proxy.Request) (*proxy.Response, error) {
+		return &proxy.Response{Data: map[string]interface{}{"id": 1, "name": "alice"}, IsComplete: true}, nil
+	})
+	// merges another backend into the users response and transforms it, as endpoints do
+	endpoint := func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+		response, err := usersProxy(ctx, request)
+		response.Data["roles"] = []interface{}{"admin"}
+		response.Data["name"] = "ALICE"
+		return response, err
+	}
+
+	shadowProxy := func(name string) proxy.Proxy {
+		return func(context.Context, *proxy.Request) (*proxy.Response, error) {
+			return &proxy.Response{Data: map[string]interface{}{"id": 1, "name": name}, IsComplete: true}, nil
+		}
+	}
+	opts := ShadowOptions{SampleRate: 100, Timeout: time.Second, LogDiff: true, Primary: "users"}
+	p := newShadowMiddleware(newLogger(zap.New(core)),
+		shadow{name: "next", opts: opts, proxy: shadowProxy("alice")},
+		shadow{name: "legacy", opts: opts, proxy: shadowProxy("bob")},
+	)(endpoint)
+
+	response, err := p(context.Background(), &proxy.Request{Path: "/users/1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "ALICE", response.Data["name"])
+
+	assert.Eventually(t, logged("shadow backend legacy differs from users: changed name"), time.Second, 5*time.Millisecond)
+	assert.Never(t, logged("shadow backend next differs from users: changed name, missing roles"), 50*time.Millisecond, 5*time.Millisecond,
+		"shadows are compared with the response of the primary backend, before it is merged and transformed")
+	assert.Equal(t, 1, logs.Len())
+}
+
+func TestDiffData(t *testing.T) {
+	expected := map[string]interface{}{
+		"id":    1.0,
+		"name":  "alice",
+		"email": "alice@example.com",
+		"address": map[string]interface{}{
+			"city": "Lisbon",
+			"zip":  "1000",
+		},
+	}
+	actual := map[string]interface{}{
+		"id":   1,
+		"name": "Alice",
+		"address": map[string]interface{}{
+			"city": "Lisbon",
+			"zip":  "1100",
+		},
+		"roles": []interface{}{"admin"},
+	}
+
+	assert.Equal(t, []string{"changed address.zip", "changed name", "missing email", "unexpected roles"}, diffData(expected, actual))
+	assert.Empty(t, diffData(expected, expected))
+}
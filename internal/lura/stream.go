@@ -0,0 +1,302 @@
+package lura
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/core"
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/luraproject/lura/v2/sd"
+	"github.com/luraproject/lura/v2/transport/http/server"
+	"github.com/xico42/caddy-lura/internal/httprouter"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStreamHeartbeat = 15 * time.Second
+	defaultStreamReconnect = time.Second
+
+	// maxStreamLineSize bounds the size of a single line read from a backend stream.
+	maxStreamLineSize = 1 << 20
+)
+
+// StreamOptions turns an endpoint into a Server-Sent Events endpoint: streams are opened with every backend
+// and their events are multiplexed into a single event stream for the client.
+type StreamOptions struct {
+	// Heartbeat is the interval between the comments sent to keep the client connection alive.
+	// If zero, 15 seconds are assumed. Negative values disable the heartbeat.
+	Heartbeat time.Duration
+
+	// Reconnect is the delay before reopening the stream of a backend that dropped or failed.
+	// If zero, 1 second is assumed. Backends may override it with the SSE "retry" field.
+	Reconnect time.Duration
+}
+
+// streamEvent is a single event read from a backend stream.
+type streamEvent struct {
+	tag   string
+	event string
+	data  string
+}
+
+// newStreamHandle creates the handle of a Server-Sent Events endpoint.
+//
+// Backends may answer either with Server-Sent Events (text/event-stream), or with any other content type,
+// whose non-empty lines are taken as the data of one event each, e.g. JSON lines.
+// Events are tagged with the backend group, or with the backend name if it has no group: the event type is
+// the tag for unnamed events, and "<tag>.<event type>" for named ones. Backend event ids are not relayed.
+//
+// The endpoint timeout bounds the time taken by each backend to answer with its headers. Streams are read
+// until the client goes away, which cancels the request context and every backend request along with it.
+func newStreamHandle(cfg *config.EndpointConfig, opts StreamOptions, clients *clientPool, logger logging.Logger) httprouter.Handle {
+	if opts.Heartbeat == 0 {
+		opts.Heartbeat = defaultStreamHeartbeat
+	}
+	if opts.Reconnect == 0 {
+		opts.Reconnect = defaultStreamReconnect
+	}
+
+	headersToSend := cfg.HeadersToPass
+	if len(headersToSend) == 0 {
+		headersToSend = server.HeadersToSend
+	}
+
+	backends := make([]*streamBackend, 0, len(cfg.Backend))
+	for _, remote := range cfg.Backend {
+		tag := remote.Group
+		if tag == "" {
+			tag = backendOptions(remote).Name
+		}
+
+		backends = append(backends, &streamBackend{
+			remote:    remote,
+			client:    clients.get(backendOptions(remote).Transport),
+			tag:       tag,
+			balancer:  sd.NewRoundRobinLB(sd.FixedSubscriber(remote.Host)),
+			timeout:   cfg.Timeout,
+			reconnect: opts.Reconnect,
+			logger:    logger,
+		})
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		proxyRequest := buildProxyRequest(r, cfg.QueryString, headersToSend, params)
+		rawQuery := url.Values(proxyRequest.Query).Encode()
+		replacer := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+
+		events := make(chan streamEvent)
+		for _, b := range backends {
+			backendURL, err := resolveBackendURL(b.balancer, replacer, b.remote.URLPattern, rawQuery)
+			if err != nil {
+				return caddyhttp.Error(http.StatusInternalServerError, err)
+			}
+			go b.run(ctx, backendURL, proxyRequest.Headers, events)
+		}
+
+		w.Header().Set(core.KrakendHeaderName, core.KrakendHeaderValue)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		rc := http.NewResponseController(w)
+		if err := rc.Flush(); err != nil {
+			return err
+		}
+
+		var heartbeat <-chan time.Time
+		if opts.Heartbeat > 0 {
+			ticker := time.NewTicker(opts.Heartbeat)
+			defer ticker.Stop()
+			heartbeat = ticker.C
+		}
+
+		for {
+			var err error
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-heartbeat:
+				_, err = io.WriteString(w, ": heartbeat\n\n")
+			case e := <-events:
+				err = writeStreamEvent(w, e)
+			}
+			if err == nil {
+				err = rc.Flush()
+			}
+			if err != nil {
+				// the client went away
+				return nil
+			}
+		}
+	}
+}
+
+func writeStreamEvent(w io.Writer, e streamEvent) error {
+	event := e.tag
+	if e.event != "" && e.event != "message" {
+		event += "." + e.event
+	}
+
+	var sb strings.Builder
+	sb.WriteString("event: " + event + "\n")
+	for _, line := range strings.Split(e.data, "\n") {
+		sb.WriteString("data: " + line + "\n")
+	}
+	sb.WriteString("\n")
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// streamBackend reads the event stream of a single backend.
+type streamBackend struct {
+	remote    *config.Backend
+	client    *http.Client
+	tag       string
+	balancer  sd.Balancer
+	timeout   time.Duration
+	reconnect time.Duration
+	logger    logging.Logger
+}
+
+// run reads the backend stream into events, reopening it whenever it ends, until ctx is done.
+func (b *streamBackend) run(ctx context.Context, backendURL string, headers map[string][]string, events chan<- streamEvent) {
+	state := &streamState{reconnect: b.reconnect}
+
+	for {
+		err := b.read(ctx, backendURL, headers, state, events)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = io.EOF
+		}
+		b.logger.Debug(logPrefix, "stream: backend", b.tag, "dropped, reconnecting:", err.Error())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(state.reconnect):
+		}
+	}
+}
+
+// streamState holds what is kept between the connections to a backend.
+type streamState struct {
+	lastEventID string
+	reconnect   time.Duration
+}
+
+func (b *streamBackend) read(ctx context.Context, backendURL string, headers map[string][]string, state *streamState, events chan<- streamEvent) error {
+	method := b.remote.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	reqCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, strings.ToUpper(method), backendURL, nil)
+	if err != nil {
+		return err
+	}
+	for k, vs := range headers {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Accept", "text/event-stream, application/x-ndjson;q=0.9, */*;q=0.8")
+	if state.lastEventID != "" {
+		req.Header.Set("Last-Event-ID", state.lastEventID)
+	}
+
+	// the timeout only applies until the headers are received, streams are read as long as the client stays
+	timer := time.AfterFunc(b.timeout, cancel)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		timer.Stop()
+		return err
+	}
+	defer resp.Body.Close()
+	if !timer.Stop() {
+		return context.DeadlineExceeded
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 4096), maxStreamLineSize)
+
+	send := func(e streamEvent) bool {
+		select {
+		case events <- e:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/event-stream" {
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !send(streamEvent{tag: b.tag, data: line}) {
+				return nil
+			}
+		}
+		return scanner.Err()
+	}
+
+	var event streamEvent
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if len(data) > 0 {
+				event.tag = b.tag
+				event.data = strings.Join(data, "\n")
+				if !send(event) {
+					return nil
+				}
+			}
+			event, data = streamEvent{}, nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "data":
+			data = append(data, value)
+		case "event":
+			event.event = value
+		case "id":
+			state.lastEventID = value
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms > 0 {
+				state.reconnect = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package lura
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/xico42/caddy-lura/internal/httprouter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStreamHandle(t *testing.T) {
+	var mu sync.Mutex
+	var lastEventIDs []string
+	var connections atomic.Int32
+
+	// sends two events per connection, then drops the stream
+	sse := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := connections.Add(1)
+		mu.Lock()
+		lastEventIDs = append(lastEventIDs, r.Header.Get("Last-Event-ID"))
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "retry: 10\n\n: comment\n\nid: %d\ndata: first\ndata: line\n\n", n)
+		fmt.Fprintf(w, "event: update\ndata: second\n\n")
+	}))
+	defer sse.Close()
+
+	backendDone := make(chan struct{})
+	jsonLines := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		fmt.Fprintf(w, "{\"path\": %q}\n\n", r.URL.RequestURI())
+		w.(http.Flusher).Flush()
+
+		<-r.Context().Done()
+		close(backendDone)
+	}))
+	defer jsonLines.Close()
+
+	router := httprouter.New()
+	router.Handle(http.MethodGet, "/events/:user", newStreamHandle(&config.EndpointConfig{
+		Endpoint:    "/events/:user",
+		Timeout:     time.Second,
+		QueryString: []string{"since"},
+		Backend: []*config.Backend{
+			{Host: []string{sse.URL}, URLPattern: "/notifications", Group: "notifications"},
+			{
+				Host:        []string{jsonLines.URL},
+				URLPattern:  "/users/{user}/activity",
+				ExtraConfig: config.ExtraConfig{Namespace: BackendOptions{Name: "activity"}},
+			},
+		},
+	}, StreamOptions{Heartbeat: 50 * time.Millisecond, Reconnect: time.Hour}, newClientPool(config.ServiceConfig{}, nil, logging.NoOp), logging.NoOp))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer())
+		ctx = context.WithValue(ctx, caddyhttp.VarsCtxKey, map[string]any{caddyhttp.ClientIPVarKey: "127.0.0.1"})
+		assert.NoError(t, router.ServeHTTP(w, r.WithContext(ctx)))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events/42?since=1&admin=true", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// collects events until the sse backend reconnected once and a heartbeat was sent
+	events := make(map[string]int)
+	var heartbeat bool
+	var sb strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" {
+			sb.WriteString(line + "\n")
+			continue
+		}
+
+		event := sb.String()
+		sb.Reset()
+		if event == ": heartbeat\n" {
+			heartbeat = true
+		} else {
+			events[event]++
+		}
+
+		if heartbeat && events["event: notifications\ndata: first\ndata: line\n"] >= 2 {
+			break
+		}
+	}
+
+	assert.Equal(t, 1, events["event: activity\ndata: {\"path\": \"/users/42/activity?since=1\"}\n"], "only the allowed query strings are forwarded")
+	assert.GreaterOrEqual(t, events["event: notifications.update\ndata: second\n"], 1)
+	mu.Lock()
+	assert.Equal(t, []string{"", "1"}, lastEventIDs[:2], "reconnections send the last event id")
+	mu.Unlock()
+
+	cancel()
+	resp.Body.Close()
+
+	select {
+	case <-backendDone:
+	case <-time.After(time.Second):
+		t.Error("backend requests should be canceled when the client goes away")
+	}
+}
+
+func TestStreamHandleNoHosts(t *testing.T) {
+	handle := newStreamHandle(&config.EndpointConfig{
+		Endpoint: "/events",
+		Timeout:  time.Second,
+		Backend:  []*config.Backend{{URLPattern: "/notifications"}},
+	}, StreamOptions{}, newClientPool(config.ServiceConfig{}, nil, logging.NoOp), logging.NoOp)
+
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	ctx := context.WithValue(req.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer())
+	ctx = context.WithValue(ctx, caddyhttp.VarsCtxKey, map[string]any{caddyhttp.ClientIPVarKey: "127.0.0.1"})
+
+	err := handle(httptest.NewRecorder(), req.WithContext(ctx), nil)
+	var handlerErr caddyhttp.HandlerError
+	if assert.ErrorAs(t, err, &handlerErr) {
+		assert.Equal(t, http.StatusInternalServerError, handlerErr.StatusCode)
+	}
+}
@@ -0,0 +1,67 @@
+package lura
+
+import (
+	"bytes"
+	"github.com/Masterminds/sprig/v3"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/luraproject/lura/v2/proxy"
+	"net/http"
+	"text/template"
+)
+
+const defaultTemplateContentType = "application/json"
+
+// templateContext is the root object available to response templates.
+type templateContext struct {
+	// Data holds the merged response data of the endpoint.
+	Data map[string]interface{}
+
+	// IsComplete tells whether all the backends answered successfully.
+	IsComplete bool
+
+	req *http.Request
+}
+
+// Placeholder returns the value of the given caddy placeholder for the current request,
+// e.g. {{ .Placeholder "http.request.header.X-Tenant-Id" }}.
+func (c templateContext) Placeholder(key string) string {
+	replacer, ok := c.req.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		return ""
+	}
+
+	v, _ := replacer.GetString(key)
+	return v
+}
+
+// NewTemplateRender parses the given text/template source and returns a Render that executes it against
+// the endpoint response. All sprig functions (https://masterminds.github.io/sprig/) are available.
+// If contentType is empty, "application/json" is used.
+func NewTemplateRender(name, source, contentType string) (Render, error) {
+	tmpl, err := template.New(name).Funcs(sprig.TxtFuncMap()).Parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType == "" {
+		contentType = defaultTemplateContentType
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, response *proxy.Response) error {
+		tplCtx := templateContext{req: r}
+		if response != nil {
+			tplCtx.Data = response.Data
+			tplCtx.IsComplete = response.IsComplete
+		}
+
+		buf := new(bytes.Buffer)
+		if err := tmpl.Execute(buf, tplCtx); err != nil {
+			return caddyhttp.Error(http.StatusInternalServerError, err)
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		_, err := buf.WriteTo(w)
+		return err
+	}, nil
+}
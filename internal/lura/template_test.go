@@ -0,0 +1,41 @@
+package lura
+
+import (
+	"context"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/luraproject/lura/v2/proxy"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewTemplateRender(t *testing.T) {
+	render, err := NewTemplateRender(
+		"test",
+		`{"name": {{ .Data.name | upper | quote }}, "tenant": {{ .Placeholder "tenant" | quote }}, "complete": {{ .IsComplete }}}`,
+		"",
+	)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	replacer := caddy.NewReplacer()
+	replacer.Set("tenant", "acme")
+	req := httptest.NewRequest("GET", "/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), caddy.ReplacerCtxKey, replacer))
+
+	w := httptest.NewRecorder()
+	err = render(w, req, &proxy.Response{
+		Data:       map[string]interface{}{"name": "john"},
+		IsComplete: true,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, `{"name": "JOHN", "tenant": "acme", "complete": true}`, w.Body.String())
+}
+
+func TestNewTemplateRenderInvalid(t *testing.T) {
+	_, err := NewTemplateRender("test", "{{ .Data.name ", "")
+	assert.Error(t, err)
+}
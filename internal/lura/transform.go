@@ -0,0 +1,138 @@
+package lura
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/PaesslerAG/jsonpath"
+	"github.com/jmespath/go-jmespath"
+	"github.com/luraproject/lura/v2/proxy"
+	"strings"
+)
+
+const (
+	// TransformJMESPath identifies transform expressions written in JMESPath (https://jmespath.org/).
+	TransformJMESPath = "jmespath"
+
+	// TransformJSONPath identifies transform expressions written in JSONPath (https://goessner.net/articles/JsonPath/).
+	TransformJSONPath = "jsonpath"
+
+	// maxExactFloat is the largest integer from which all the integers are exactly representable as float64.
+	maxExactFloat = 1 << 53
+
+	collectionKey = "collection"
+	contentKey    = "content"
+)
+
+// Transformer reshapes the data of a response.
+//
+// Results that are not JSON objects are wrapped, so that they can still be carried by a proxy.Response:
+// arrays are stored under the "collection" key and any other value under the "content" key.
+type Transformer func(data map[string]interface{}) (map[string]interface{}, error)
+
+// NewTransformer compiles the expression written in the given language into a Transformer.
+// An empty language defaults to JMESPath.
+func NewTransformer(language, expression string) (Transformer, error) {
+	var search func(interface{}) (interface{}, error)
+
+	switch strings.ToLower(language) {
+	case "", TransformJMESPath:
+		jp, err := jmespath.Compile(expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jmespath expression '%s': %w", expression, err)
+		}
+		search = jp.Search
+
+	case TransformJSONPath:
+		eval, err := jsonpath.New(expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jsonpath expression '%s': %w", expression, err)
+		}
+		search = func(data interface{}) (interface{}, error) {
+			return eval(context.Background(), data)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported transform language '%s'", language)
+	}
+
+	return func(data map[string]interface{}) (map[string]interface{}, error) {
+		result, err := search(normalizeNumbers(data))
+		if err != nil {
+			return nil, err
+		}
+
+		switch v := result.(type) {
+		case map[string]interface{}:
+			return v, nil
+		case []interface{}:
+			return map[string]interface{}{collectionKey: v}, nil
+		case nil:
+			return map[string]interface{}{}, nil
+		default:
+			return map[string]interface{}{contentKey: v}, nil
+		}
+	}, nil
+}
+
+func newTransformMiddleware(transform Transformer) proxy.Middleware {
+	return func(next ...proxy.Proxy) proxy.Proxy {
+		if transform == nil {
+			return next[0]
+		}
+
+		return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+			response, err := next[0](ctx, request)
+			if response == nil || response.Data == nil {
+				return response, err
+			}
+
+			data, transformErr := transform(response.Data)
+			if transformErr != nil {
+				return nil, transformErr
+			}
+			response.Data = data
+
+			return response, err
+		}
+	}
+}
+
+// normalizeNumbers converts the json.Number values produced by lura's decoders into float64, the only
+// numeric type the expression evaluators compare. Integers that float64 cannot represent exactly, such as
+// 64-bit identifiers, are kept as int64 instead, or as json.Number when they do not fit in an int64 either,
+// so that they are not corrupted.
+func normalizeNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, item := range t {
+			out[k] = normalizeNumbers(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, item := range t {
+			out[i] = normalizeNumbers(item)
+		}
+		return out
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			if i > maxExactFloat || i < -maxExactFloat {
+				return i
+			}
+			return float64(i)
+		}
+		if !strings.ContainsAny(t.String(), ".eE") {
+			// an integer out of the int64 range, such as an uint64 identifier
+			return t
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return t.String()
+		}
+		return f
+	default:
+		return v
+	}
+}
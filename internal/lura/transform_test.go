@@ -0,0 +1,99 @@
+package lura
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewTransformer(t *testing.T) {
+	data := map[string]interface{}{
+		"id": json.Number("42"),
+		"permissions": []interface{}{
+			map[string]interface{}{"name": "view", "level": json.Number("1")},
+			map[string]interface{}{"name": "manage", "level": json.Number("3")},
+		},
+	}
+
+	testCases := []struct {
+		name       string
+		language   string
+		expression string
+		expected   map[string]interface{}
+	}{
+		{
+			name:       "jmespath projection",
+			expression: "{id: id, roles: permissions[?level > `2`].name}",
+			expected: map[string]interface{}{
+				"id":    float64(42),
+				"roles": []interface{}{"manage"},
+			},
+		},
+		{
+			name:       "jmespath collection",
+			language:   TransformJMESPath,
+			expression: "permissions[*].name",
+			expected: map[string]interface{}{
+				"collection": []interface{}{"view", "manage"},
+			},
+		},
+		{
+			name:       "jsonpath scalar",
+			language:   TransformJSONPath,
+			expression: "$.permissions[0].name",
+			expected: map[string]interface{}{
+				"content": "view",
+			},
+		},
+		{
+			name:       "jmespath no match",
+			expression: "missing",
+			expected:   map[string]interface{}{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			transform, err := NewTransformer(tc.language, tc.expression)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+
+			actual, err := transform(data)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestNewTransformerLargeIntegers(t *testing.T) {
+	transform, err := NewTransformer(TransformJMESPath, "{id: id, ids: ids, ratio: ratio, uid: uid}")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	actual, err := transform(map[string]interface{}{
+		"id":    json.Number("9007199254740993"),
+		"ids":   []interface{}{json.Number("-9007199254740993"), json.Number("7")},
+		"ratio": json.Number("0.5"),
+		"uid":   json.Number("18446744073709551615"),
+	})
+	assert.NoError(t, err)
+
+	b, err := json.Marshal(actual)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"id": 9007199254740993, "ids": [-9007199254740993, 7], "ratio": 0.5, "uid": 18446744073709551615}`, string(b))
+	assert.Contains(t, string(b), "9007199254740993", "integers above 2^53 are kept exact")
+	assert.Contains(t, string(b), "18446744073709551615", "integers above 2^63 are kept exact")
+}
+
+func TestNewTransformerInvalid(t *testing.T) {
+	_, err := NewTransformer(TransformJMESPath, "foo[")
+	assert.Error(t, err)
+
+	_, err = NewTransformer(TransformJSONPath, "$.[")
+	assert.Error(t, err)
+
+	_, err = NewTransformer("xpath", "/foo")
+	assert.Error(t, err)
+}
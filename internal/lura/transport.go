@@ -0,0 +1,177 @@
+package lura
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/luraproject/lura/v2/proxy"
+	"golang.org/x/net/http2"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// defaults of http.DefaultTransport
+const (
+	defaultKeepAlive             = 30 * time.Second
+	defaultMaxIdleConns          = 100
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultExpectContinueTimeout = time.Second
+)
+
+// TransportOptions configures the HTTP transport used to reach a backend.
+type TransportOptions struct {
+	// DialTimeout bounds the time taken to open a connection. Zero means no limit.
+	DialTimeout time.Duration
+
+	// ResponseHeaderTimeout bounds the time waited for the response headers once the request is written.
+	// Zero means no limit.
+	ResponseHeaderTimeout time.Duration
+
+	// TLSHandshakeTimeout bounds the time taken by the TLS handshake. Zero means the default of 10 seconds.
+	TLSHandshakeTimeout time.Duration
+
+	// TLSConfig is the TLS configuration used with "https" hosts. If nil, the default configuration is used.
+	TLSConfig *tls.Config
+
+	// MaxIdleConns caps the idle connections kept across all hosts. Zero means the default of 100.
+	MaxIdleConns int
+
+	// MaxIdleConnsPerHost caps the idle connections kept per host. Zero means the default of 2.
+	MaxIdleConnsPerHost int
+
+	// KeepAlive is the interval between TCP keep-alive probes. Zero means 30 seconds, and negative values
+	// disable both TCP keep-alives and the reuse of connections.
+	KeepAlive time.Duration
+
+	// Versions are the HTTP versions used with the backend: "1.1", "2", and "h2c" for HTTP/2 over cleartext
+	// with "http" hosts. If empty, "1.1" and "2" are used.
+	Versions []string
+
+	// Proxy is the URL of the proxy requests are sent through. If nil, the proxy is taken from
+	// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+	Proxy *url.URL
+}
+
+// tlsConfig returns the TLS configuration of the transport, nil meaning the default one.
+func (opts *TransportOptions) tlsConfig() *tls.Config {
+	if opts == nil {
+		return nil
+	}
+	return opts.TLSConfig
+}
+
+// dialer returns the dialer opening the connections of the transport, nil meaning the default one.
+func (opts *TransportOptions) dialer() *net.Dialer {
+	dialer := &net.Dialer{KeepAlive: defaultKeepAlive}
+	if opts == nil {
+		return dialer
+	}
+
+	dialer.Timeout = opts.DialTimeout
+	if opts.KeepAlive != 0 {
+		dialer.KeepAlive = opts.KeepAlive
+	}
+	return dialer
+}
+
+// newHTTPClient creates a client whose transport has the defaults of http.DefaultTransport, adjusted with opts.
+// Connections to the hosts found in sockets are made to the Unix socket they stand for.
+func newHTTPClient(opts TransportOptions, sockets map[string]string) *http.Client {
+	// http.DefaultTransport is not cloned, since other modules may have replaced it
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          defaultMaxIdleConns,
+		IdleConnTimeout:       defaultIdleConnTimeout,
+		TLSHandshakeTimeout:   defaultTLSHandshakeTimeout,
+		ExpectContinueTimeout: defaultExpectContinueTimeout,
+	}
+
+	dialer := opts.dialer()
+	dial := newUnixSocketDialer(sockets, dialer.DialContext)
+	transport.DialContext = dial
+	transport.DisableKeepAlives = opts.KeepAlive < 0
+
+	if opts.ResponseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = opts.ResponseHeaderTimeout
+	}
+	if opts.TLSHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = opts.TLSHandshakeTimeout
+	}
+	if opts.TLSConfig != nil {
+		transport.TLSClientConfig = opts.TLSConfig.Clone()
+	}
+	if opts.MaxIdleConns > 0 {
+		transport.MaxIdleConns = opts.MaxIdleConns
+	}
+	if opts.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	}
+	if opts.Proxy != nil {
+		transport.Proxy = http.ProxyURL(opts.Proxy)
+	}
+
+	if len(opts.Versions) > 0 && !hasVersion(opts.Versions, "2") {
+		// a non-nil empty map disables HTTP/2 over TLS
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	if !hasVersion(opts.Versions, "h2c") {
+		return &http.Client{Transport: transport}
+	}
+
+	h2c := &http2.Transport{
+		AllowHTTP: true,
+		// h2c connections are plaintext ones, the transport only believes they are TLS ones
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		},
+	}
+	if dialer.KeepAlive > 0 {
+		h2c.ReadIdleTimeout = dialer.KeepAlive
+	}
+	return &http.Client{Transport: h2cRoundTripper{https: transport, h2c: h2c}}
+}
+
+// h2cRoundTripper sends the requests to "http" hosts with HTTP/2 over cleartext, and any other request
+// with the regular transport.
+type h2cRoundTripper struct {
+	https *http.Transport
+	h2c   *http2.Transport
+}
+
+func (rt h2cRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" {
+		return rt.h2c.RoundTrip(req)
+	}
+	return rt.https.RoundTrip(req)
+}
+
+func (rt h2cRoundTripper) CloseIdleConnections() {
+	rt.https.CloseIdleConnections()
+	rt.h2c.CloseIdleConnections()
+}
+
+func hasVersion(versions []string, version string) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// newTimeoutMiddleware bounds each call to the backend with the given timeout, on top of the endpoint one.
+func newTimeoutMiddleware(timeout time.Duration) proxy.Middleware {
+	return func(next ...proxy.Proxy) proxy.Proxy {
+		return func(ctx context.Context, request *proxy.Request) (*proxy.Response, error) {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			return next[0](ctx, request)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package lura
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/luraproject/lura/v2/proxy"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestTimeoutMiddleware(t *testing.T) {
+	slow := func(ctx context.Context, _ *proxy.Request) (*proxy.Response, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			return &proxy.Response{IsComplete: true}, nil
+		}
+	}
+
+	start := time.Now()
+	_, err := newTimeoutMiddleware(20*time.Millisecond)(slow)(context.Background(), &proxy.Request{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = newTimeoutMiddleware(time.Minute)(slow)(ctx, &proxy.Request{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "the request deadline still applies")
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestNewHTTPClientResponseHeaderTimeout(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer backend.Close()
+
+	c := newHTTPClient(TransportOptions{ResponseHeaderTimeout: 20 * time.Millisecond}, nil)
+
+	start := time.Now()
+	_, err := c.Get(backend.URL)
+	assert.Error(t, err)
+	assert.True(t, RetryOptions{}.retryable(err), "header timeouts are retried")
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestNewHTTPClientReplacedDefaultTransport(t *testing.T) {
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("replaced")
+	})
+	defer func() { http.DefaultTransport = defaultTransport }()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	resp, err := newHTTPClient(TransportOptions{}, nil).Get(backend.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestNewHTTPClientTLS(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(r.Proto))
+	}))
+	backend.EnableHTTP2 = true
+	backend.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	backend.StartTLS()
+	defer backend.Close()
+
+	certs := backend.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	_, err := newHTTPClient(TransportOptions{}, nil).Get(backend.URL)
+	assert.Error(t, err, "the backend certificate is not trusted by default")
+
+	tlsConfig := &tls.Config{
+		RootCAs:      certs,
+		Certificates: backend.TLS.Certificates,
+	}
+
+	resp, err := newHTTPClient(TransportOptions{TLSConfig: tlsConfig}, nil).Get(backend.URL)
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "HTTP/2.0", string(body))
+	}
+
+	resp, err = newHTTPClient(TransportOptions{TLSConfig: tlsConfig, Versions: []string{"1.1"}}, nil).Get(backend.URL)
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "HTTP/1.1", string(body))
+	}
+}
+
+func TestNewHTTPClientH2C(t *testing.T) {
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}), &http2.Server{}))
+	defer backend.Close()
+
+	resp, err := newHTTPClient(TransportOptions{Versions: []string{"h2c"}}, nil).Get(backend.URL)
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "HTTP/2.0", string(body))
+	}
+}
+
+func TestNewHTTPClientProxy(t *testing.T) {
+	var proxied string
+	outbound := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer outbound.Close()
+
+	proxyURL, _ := url.Parse(outbound.URL)
+	resp, err := newHTTPClient(TransportOptions{Proxy: proxyURL}, nil).Get("http://users.internal/users/1")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, "http://users.internal/users/1", proxied)
+	}
+}
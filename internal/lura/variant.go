@@ -0,0 +1,147 @@
+package lura
+
+import (
+	"github.com/caddyserver/caddy/v2"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/sd"
+	"github.com/xico42/caddy-lura/internal/httprouter"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"net/url"
+)
+
+// VariantPlaceholderPrefix prefixes the name of the backend in the placeholder holding the variant chosen for the request.
+const VariantPlaceholderPrefix = "lura.variant."
+
+// SplitOptions configures the splitting of the traffic of a backend between its variants.
+type SplitOptions struct {
+	// Variants are the versions of the backend receiving a share of its traffic.
+	Variants []VariantOptions
+
+	// Key is resolved with the caddy replacer into the key assigning requests to variants: requests with the same
+	// key are sent to the same variant as long as the weights do not change. Requests with an empty key are
+	// assigned at random.
+	Key string
+
+	// Header is the response header set to the name of the chosen variant. Empty means no header.
+	Header string
+}
+
+// VariantOptions describes a version of a backend.
+type VariantOptions struct {
+	Name string
+
+	// Weight is the share of the traffic of the backend sent to the variant, relative to the other variants.
+	Weight int
+
+	// Host lists the hosts of the variant.
+	Host []string
+
+	// URLPattern replaces the url pattern of the backend for the calls sent to the variant.
+	URLPattern string
+}
+
+// pick returns the variant assigned to key.
+func (o SplitOptions) pick(key string) *VariantOptions {
+	total := 0
+	for _, v := range o.Variants {
+		total += v.Weight
+	}
+	if total <= 0 {
+		return nil
+	}
+
+	var n int
+	if key == "" {
+		n = rand.Intn(total)
+	} else {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		n = int(h.Sum32() % uint32(total))
+	}
+
+	for i := range o.Variants {
+		if n < o.Variants[i].Weight {
+			return &o.Variants[i]
+		}
+		n -= o.Variants[i].Weight
+	}
+
+	return nil
+}
+
+// variant returns the variant of the backend chosen for the request, choosing it if it was not already.
+// The choice is kept in the replacer, under VariantPlaceholderPrefix followed by the backend name.
+func (o SplitOptions) variant(replacer *caddy.Replacer, backendName string) *VariantOptions {
+	placeholder := VariantPlaceholderPrefix + backendName
+	if name, ok := replacer.GetString(placeholder); ok {
+		for i := range o.Variants {
+			if o.Variants[i].Name == name {
+				return &o.Variants[i]
+			}
+		}
+	}
+
+	v := o.pick(replacer.ReplaceAll(o.Key, ""))
+	if v != nil {
+		replacer.Set(placeholder, v.Name)
+	}
+	return v
+}
+
+// withVariants chooses the variants of the backends of the endpoint splitting their traffic before calling next,
+// so that the chosen variants are available as placeholders and response headers.
+func withVariants(c *config.EndpointConfig, next httprouter.Handle) httprouter.Handle {
+	remotes := make([]*config.Backend, 0)
+	for _, remote := range c.Backend {
+		// the variants of shadow backends are left out of the response, as the backends themselves
+		if opts := backendOptions(remote); opts.Split != nil && opts.Shadow == nil {
+			remotes = append(remotes, remote)
+		}
+	}
+	if len(remotes) == 0 {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+		replacer := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+		for _, remote := range remotes {
+			opts := backendOptions(remote)
+			if v := opts.Split.variant(replacer, opts.Name); v != nil && opts.Split.Header != "" {
+				w.Header().Add(opts.Split.Header, v.Name)
+			}
+		}
+		return next(w, r, params)
+	}
+}
+
+// variantBalancers returns a balancer per variant of the backend, by name.
+func variantBalancers(remote *config.Backend, split SplitOptions) map[string]sd.Balancer {
+	balancers := make(map[string]sd.Balancer, len(split.Variants))
+	for _, v := range split.Variants {
+		variant := *remote
+		variant.Host = v.Host
+		balancers[v.Name] = sd.NewBalancer(sd.GetRegister().Get(remote.SD)(&variant))
+	}
+	return balancers
+}
+
+// variantURL returns the URL of the call to the variant, with the resolved path and the query of u.
+func variantURL(balancer sd.Balancer, path string, u *url.URL) (*url.URL, error) {
+	host, err := balancer.Host()
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+	target.Path += path
+	if u != nil {
+		target.RawQuery = u.RawQuery
+	}
+
+	return target, nil
+}
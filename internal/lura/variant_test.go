@@ -0,0 +1,88 @@
+package lura
+
+import (
+	"context"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/xico42/caddy-lura/internal/httprouter"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestSplitOptionsPick(t *testing.T) {
+	split := SplitOptions{Variants: []VariantOptions{{Name: "v1", Weight: 90}, {Name: "v2", Weight: 10}, {Name: "v3"}}}
+
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		counts[split.pick("").Name]++
+	}
+	assert.InDelta(t, 9000, counts["v1"], 500)
+	assert.InDelta(t, 1000, counts["v2"], 500)
+	assert.Zero(t, counts["v3"], "variants without weight get no traffic")
+
+	for _, key := range []string{"alice", "bob", "10.0.0.1"} {
+		v := split.pick(key)
+		for i := 0; i < 10; i++ {
+			assert.Same(t, v, split.pick(key), "the same key is assigned the same variant")
+		}
+	}
+
+	assert.Nil(t, SplitOptions{Variants: []VariantOptions{{Name: "v1"}}}.pick("alice"))
+}
+
+func TestSplitOptionsVariant(t *testing.T) {
+	split := SplitOptions{
+		Variants: []VariantOptions{{Name: "v1", Weight: 50}, {Name: "v2", Weight: 50}},
+		Key:      "{user}",
+	}
+
+	replacer := caddy.NewReplacer()
+	replacer.Set("user", "alice")
+	v := split.variant(replacer, "users")
+	name, _ := replacer.GetString(VariantPlaceholderPrefix + "users")
+	assert.Equal(t, v.Name, name, "the chosen variant is exposed as a placeholder")
+
+	replacer.Set(VariantPlaceholderPrefix+"users", "v2")
+	assert.Equal(t, "v2", split.variant(replacer, "users").Name, "the variant already chosen is kept")
+}
+
+func TestWithVariants(t *testing.T) {
+	split := &SplitOptions{
+		Variants: []VariantOptions{{Name: "v1", Weight: 1}},
+		Header:   "X-Lura-Variant",
+	}
+	c := &config.EndpointConfig{Backend: []*config.Backend{
+		{ExtraConfig: config.ExtraConfig{Namespace: BackendOptions{Name: "users", Split: split}}},
+		{},
+	}}
+
+	var placeholder string
+	handle := withVariants(c, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) error {
+		placeholder, _ = r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer).GetString(VariantPlaceholderPrefix + "users")
+		return nil
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req = req.WithContext(context.WithValue(req.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer()))
+	w := httptest.NewRecorder()
+	assert.NoError(t, handle(w, req, nil))
+	assert.Equal(t, "v1", placeholder)
+	assert.Equal(t, "v1", w.Header().Get("X-Lura-Variant"))
+}
+
+func TestVariantURL(t *testing.T) {
+	remote := &config.Backend{Host: []string{"http://users:8080", "http://users-v2:8080"}}
+	balancers := variantBalancers(remote, SplitOptions{Variants: []VariantOptions{
+		{Name: "v1", Host: []string{"http://users:8080"}},
+		{Name: "v2", Host: []string{"http://users-v2:8080"}},
+	}})
+
+	u, _ := url.Parse("http://users:8080/users/42?expand=roles")
+	target, err := variantURL(balancers["v2"], "/v2/users/a b", u)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "http://users-v2:8080/v2/users/a%20b?expand=roles", target.String())
+	}
+}
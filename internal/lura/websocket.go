@@ -0,0 +1,253 @@
+package lura
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/gorilla/websocket"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/core"
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/luraproject/lura/v2/sd"
+	"github.com/luraproject/lura/v2/transport/http/server"
+	"github.com/xico42/caddy-lura/internal/httprouter"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// WebSocketOptions turns an endpoint into a WebSocket endpoint: the client connection is upgraded
+// and its messages are proxied to and from a WebSocket connection opened with the endpoint backend.
+type WebSocketOptions struct {
+	// MaxMessageSize is the maximum size in bytes of a message read from either side.
+	// Larger messages close both connections with the 1009 (message too big) status. Zero means no limit.
+	MaxMessageSize int64
+
+	// MaxConnections is the maximum number of connections proxied at the same time by the endpoint.
+	// Handshakes beyond the limit are answered with 503. Zero means no limit.
+	MaxConnections int
+
+	// Auth is the URL of a service called with the forwarded headers before the connection is upgraded.
+	// It may contain caddy placeholders. Answers outside the 2xx range reject the handshake with the same status.
+	Auth string
+
+	// AllowedOrigins are the values of the Origin header of the handshakes accepted, "*" accepting any origin.
+	// If empty, only handshakes without Origin header or from the host of the request are accepted.
+	// Other handshakes are answered with 403.
+	AllowedOrigins []string
+}
+
+// webSocketHandshakeHeaders are set by the dialer itself and must not be forwarded to the backend.
+var webSocketHandshakeHeaders = map[string]struct{}{
+	"Connection":               {},
+	"Upgrade":                  {},
+	"Sec-Websocket-Key":        {},
+	"Sec-Websocket-Version":    {},
+	"Sec-Websocket-Extensions": {},
+	"Sec-Websocket-Protocol":   {},
+}
+
+// newWebSocketHandle creates the handle of a WebSocket endpoint, proxying to its first backend.
+// Backend hosts use the http and https schemes, which are dialed as ws and wss respectively, with the dialer,
+// TLS configuration and proxy of the backend transport. The auth hook is called with the client of the transport.
+func newWebSocketHandle(cfg *config.EndpointConfig, opts WebSocketOptions, clients *clientPool, logger logging.Logger) httprouter.Handle {
+	remote := cfg.Backend[0]
+	balancer := sd.NewRoundRobinLB(sd.FixedSubscriber(remote.Host))
+	transport := backendOptions(remote).Transport
+	authClient := clients.get(transport)
+
+	headersToSend := cfg.HeadersToPass
+	if len(headersToSend) == 0 {
+		headersToSend = server.HeadersToSend
+	}
+
+	var slots chan struct{}
+	if opts.MaxConnections > 0 {
+		slots = make(chan struct{}, opts.MaxConnections)
+	}
+
+	dialer := &websocket.Dialer{
+		NetDialContext:   transport.dialer().DialContext,
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: cfg.Timeout,
+	}
+	if tlsConfig := transport.tlsConfig(); tlsConfig != nil {
+		dialer.TLSClientConfig = tlsConfig.Clone()
+	}
+	if transport != nil && transport.Proxy != nil {
+		dialer.Proxy = http.ProxyURL(transport.Proxy)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) error {
+		w.Header().Set(core.KrakendHeaderName, core.KrakendHeaderValue)
+		if !websocket.IsWebSocketUpgrade(r) {
+			return caddyhttp.Error(http.StatusBadRequest, errors.New("websocket: the request is not a websocket handshake"))
+		}
+
+		if !allowedWebSocketOrigin(opts.AllowedOrigins, r) {
+			return caddyhttp.Error(http.StatusForbidden, fmt.Errorf("websocket: origin not allowed: %s", r.Header.Get("Origin")))
+		}
+
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			default:
+				return caddyhttp.Error(http.StatusServiceUnavailable, errors.New("websocket: too many connections"))
+			}
+		}
+
+		proxyRequest := buildProxyRequest(r, cfg.QueryString, headersToSend, params)
+		headers := make(http.Header, len(proxyRequest.Headers)+1)
+		for k, vs := range proxyRequest.Headers {
+			if _, ok := webSocketHandshakeHeaders[textproto.CanonicalMIMEHeaderKey(k)]; !ok {
+				headers[k] = vs
+			}
+		}
+
+		replacer := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+
+		handshakeCtx, cancel := context.WithTimeout(r.Context(), cfg.Timeout)
+		defer cancel()
+
+		if opts.Auth != "" {
+			if err := authorizeWebSocket(handshakeCtx, authClient, replacer, opts.Auth, headers); err != nil {
+				return err
+			}
+		}
+
+		backendURL, err := resolveBackendURL(balancer, replacer, remote.URLPattern, url.Values(proxyRequest.Query).Encode())
+		if err != nil {
+			return caddyhttp.Error(http.StatusInternalServerError, err)
+		}
+		backendURL = strings.Replace(backendURL, "http", "ws", 1)
+
+		if protocols := websocket.Subprotocols(r); len(protocols) > 0 {
+			headers["Sec-Websocket-Protocol"] = []string{strings.Join(protocols, ", ")}
+		}
+
+		backendConn, resp, err := dialer.DialContext(handshakeCtx, backendURL, headers)
+		if err != nil {
+			if resp != nil {
+				return caddyhttp.Error(resp.StatusCode, fmt.Errorf("websocket: backend handshake: %w", err))
+			}
+			return caddyhttp.Error(http.StatusBadGateway, fmt.Errorf("websocket: backend handshake: %w", err))
+		}
+		defer backendConn.Close()
+
+		// the origin is already checked
+		upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+		if protocol := backendConn.Subprotocol(); protocol != "" {
+			upgrader.Subprotocols = []string{protocol}
+		}
+
+		// the upgrader already answered the client on failure
+		clientConn, err := upgrader.Upgrade(hijackable{w}, r, nil)
+		if err != nil {
+			logger.Debug(logPrefix, "websocket: client handshake failed:", err.Error())
+			return nil
+		}
+		defer clientConn.Close()
+
+		if opts.MaxMessageSize > 0 {
+			clientConn.SetReadLimit(opts.MaxMessageSize)
+			backendConn.SetReadLimit(opts.MaxMessageSize)
+		}
+
+		errc := make(chan error, 2)
+		go pipeWebSocket(clientConn, backendConn, errc)
+		go pipeWebSocket(backendConn, clientConn, errc)
+
+		// the first side to stop closes both connections, which stops the other pipe
+		err = <-errc
+		clientConn.Close()
+		backendConn.Close()
+		<-errc
+
+		logger.Debug(logPrefix, "websocket: connection closed:", err.Error())
+		return nil
+	}
+}
+
+// allowedWebSocketOrigin tells whether the Origin header of the handshake is one of allowed. When allowed is
+// empty, the origin must have the host of the request, as checked by default by gorilla's upgrader.
+func allowedWebSocketOrigin(allowed []string, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if len(allowed) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	for _, o := range allowed {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizeWebSocket calls the auth hook with the forwarded headers, turning answers outside the 2xx range into errors.
+func authorizeWebSocket(ctx context.Context, client *http.Client, replacer *caddy.Replacer, authURL string, headers http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, replacer.ReplaceKnown(authURL, ""), nil)
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	req.Header = headers.Clone()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return caddyhttp.Error(http.StatusBadGateway, fmt.Errorf("websocket: auth hook: %w", err))
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return caddyhttp.Error(resp.StatusCode, errors.New("websocket: rejected by the auth hook"))
+	}
+
+	return nil
+}
+
+// pipeWebSocket copies messages from src to dst until src fails, forwarding close frames as they are received.
+func pipeWebSocket(src, dst *websocket.Conn, errc chan<- error) {
+	for {
+		messageType, message, err := src.ReadMessage()
+		if err != nil {
+			closeMessage := websocket.FormatCloseMessage(websocket.CloseGoingAway, "")
+
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) && closeErr.Code != websocket.CloseNoStatusReceived {
+				closeMessage = websocket.FormatCloseMessage(closeErr.Code, closeErr.Text)
+			} else if errors.Is(err, websocket.ErrReadLimit) {
+				// src was already closed with the same status
+				closeMessage = websocket.FormatCloseMessage(websocket.CloseMessageTooBig, "")
+			}
+
+			dst.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+			errc <- err
+			return
+		}
+
+		if err := dst.WriteMessage(messageType, message); err != nil {
+			errc <- err
+			return
+		}
+	}
+}
+
+// hijackable exposes the hijacking support of the underlying connection through caddy's response writer wrappers.
+type hijackable struct {
+	http.ResponseWriter
+}
+
+func (h hijackable) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(h.ResponseWriter).Hijack()
+}
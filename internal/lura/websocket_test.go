@@ -0,0 +1,223 @@
+package lura
+
+import (
+	"context"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/gorilla/websocket"
+	"github.com/luraproject/lura/v2/config"
+	"github.com/luraproject/lura/v2/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/xico42/caddy-lura/internal/httprouter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newWebSocketTestServer serves the handle of a WebSocket endpoint, providing the request context set up by caddy.
+func newWebSocketTestServer(t *testing.T, cfg *config.EndpointConfig, opts WebSocketOptions) *httptest.Server {
+	router := httprouter.New()
+	router.Handle(http.MethodGet, cfg.Endpoint, newWebSocketHandle(cfg, opts, newClientPool(config.ServiceConfig{}, nil, logging.NoOp), logging.NoOp))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer())
+		ctx = context.WithValue(ctx, caddyhttp.VarsCtxKey, map[string]any{caddyhttp.ClientIPVarKey: "127.0.0.1"})
+		if err := router.ServeHTTP(w, r.WithContext(ctx)); err != nil {
+			var handlerErr caddyhttp.HandlerError
+			if assert.ErrorAs(t, err, &handlerErr) {
+				w.WriteHeader(handlerErr.StatusCode)
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestWebSocketHandle(t *testing.T) {
+	var backendPath, backendHeader string
+
+	// echoes messages back, prefixed by the room
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendPath = r.URL.RequestURI()
+		backendHeader = r.Header.Get("X-Token")
+
+		upgrader := websocket.Upgrader{Subprotocols: []string{"chat"}}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(messageType, append([]byte(strings.TrimPrefix(r.URL.Path, "/rooms/")+": "), message...))
+		}
+	}))
+	defer backend.Close()
+
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer auth.Close()
+
+	server := newWebSocketTestServer(t, &config.EndpointConfig{
+		Endpoint:      "/ws/:room",
+		Timeout:       time.Second,
+		HeadersToPass: []string{"X-Token"},
+		QueryString:   []string{"since"},
+		Backend: []*config.Backend{
+			{Host: []string{backend.URL}, URLPattern: "/rooms/{room}"},
+		},
+	}, WebSocketOptions{
+		MaxMessageSize: 16,
+		MaxConnections: 1,
+		Auth:           auth.URL,
+	})
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/general?since=10&admin=true"
+	dialer := websocket.Dialer{Subprotocols: []string{"chat"}}
+
+	_, resp, err := dialer.Dial(wsURL, http.Header{"X-Token": {"wrong"}})
+	if assert.Error(t, err) && assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	}
+
+	conn, _, err := dialer.Dial(wsURL, http.Header{"X-Token": {"secret"}})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+
+	assert.Equal(t, "chat", conn.Subprotocol())
+	assert.Equal(t, "/rooms/general?since=10", backendPath, "only the allowed query strings are forwarded")
+	assert.Equal(t, "secret", backendHeader)
+
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+	_, message, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "general: hello", string(message))
+
+	_, resp, err = dialer.Dial(wsURL, http.Header{"X-Token": {"secret"}})
+	if assert.Error(t, err) && assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "connections are limited")
+	}
+
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("a message that is too big")))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig), "unexpected error: %v", err)
+}
+
+func TestWebSocketHandleTransport(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth" {
+			return
+		}
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer backend.Close()
+
+	transport := &TransportOptions{TLSConfig: backend.Client().Transport.(*http.Transport).TLSClientConfig}
+	server := newWebSocketTestServer(t, &config.EndpointConfig{
+		Endpoint: "/ws",
+		Timeout:  time.Second,
+		Backend: []*config.Backend{{
+			Host:        []string{backend.URL},
+			URLPattern:  "/rooms",
+			ExtraConfig: config.ExtraConfig{Namespace: BackendOptions{Transport: transport}},
+		}},
+	}, WebSocketOptions{Auth: backend.URL + "/auth"})
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if assert.NoError(t, err, "the backend and the auth hook are reached with the TLS configuration of the transport") {
+		conn.Close()
+	}
+}
+
+func TestWebSocketHandleAuthTimeout(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer auth.Close()
+
+	server := newWebSocketTestServer(t, &config.EndpointConfig{
+		Endpoint: "/ws",
+		Timeout:  50 * time.Millisecond,
+		Backend:  []*config.Backend{{Host: []string{"http://localhost:1"}}},
+	}, WebSocketOptions{Auth: auth.URL})
+
+	start := time.Now()
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if assert.Error(t, err) && assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	}
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "the auth hook is bound by the endpoint timeout")
+}
+
+func TestWebSocketHandleNotUpgrade(t *testing.T) {
+	server := newWebSocketTestServer(t, &config.EndpointConfig{
+		Endpoint: "/ws",
+		Timeout:  time.Second,
+		Backend:  []*config.Backend{{Host: []string{"http://localhost:1"}}},
+	}, WebSocketOptions{})
+
+	resp, err := http.Get(server.URL + "/ws")
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestWebSocketHandleOrigin(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer backend.Close()
+
+	cfg := &config.EndpointConfig{
+		Endpoint: "/ws",
+		Timeout:  time.Second,
+		Backend:  []*config.Backend{{Host: []string{backend.URL}, URLPattern: "/rooms"}},
+	}
+	dial := func(server *httptest.Server, origin string) int {
+		conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", http.Header{"Origin": {origin}})
+		if err == nil {
+			conn.Close()
+		}
+		if resp == nil {
+			return 0
+		}
+		return resp.StatusCode
+	}
+
+	sameOrigin := newWebSocketTestServer(t, cfg, WebSocketOptions{})
+	assert.Equal(t, http.StatusSwitchingProtocols, dial(sameOrigin, sameOrigin.URL))
+	assert.Equal(t, http.StatusForbidden, dial(sameOrigin, "https://app.example.com"), "only the same origin is allowed by default")
+
+	allowed := newWebSocketTestServer(t, cfg, WebSocketOptions{AllowedOrigins: []string{"https://app.example.com"}})
+	assert.Equal(t, http.StatusSwitchingProtocols, dial(allowed, "https://app.example.com"))
+	assert.Equal(t, http.StatusForbidden, dial(allowed, "https://evil.example.com"))
+
+	anyOrigin := newWebSocketTestServer(t, cfg, WebSocketOptions{AllowedOrigins: []string{"*"}})
+	assert.Equal(t, http.StatusSwitchingProtocols, dial(anyOrigin, "https://evil.example.com"))
+}
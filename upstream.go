@@ -0,0 +1,75 @@
+package caddylura
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	unixSocketPrefix  = "unix/"
+	caddyServerPrefix = "caddy://"
+)
+
+// localUpstreams assigns the hosts standing for Unix sockets and Caddy servers, as lura only reaches network hosts.
+// Assigned hosts use the reserved ".invalid" top level domain, so that they never clash with real hosts.
+type localUpstreams struct {
+	// sockets maps the assigned hosts to the paths of the sockets.
+	sockets map[string]string
+
+	// servers maps the assigned hosts to the names of the servers.
+	servers map[string]string
+}
+
+func newLocalUpstreams() *localUpstreams {
+	return &localUpstreams{
+		sockets: map[string]string{},
+		servers: map[string]string{},
+	}
+}
+
+// hosts returns the backend hosts, with Unix sockets and Caddy servers replaced with the hosts standing for them.
+// The same socket or server is always given the same host.
+func (u *localUpstreams) hosts(hosts []string) []string {
+	resolved := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		switch {
+		case isUnixSocket(h):
+			resolved = append(resolved, "http://"+u.assign(u.sockets, "unix-socket", strings.TrimPrefix(h, unixSocketPrefix)))
+		case isCaddyServer(h):
+			resolved = append(resolved, "http://"+u.assign(u.servers, "caddy-server", strings.TrimPrefix(h, caddyServerPrefix)))
+		default:
+			resolved = append(resolved, h)
+		}
+	}
+	return resolved
+}
+
+func (u *localUpstreams) assign(assigned map[string]string, prefix, target string) string {
+	for host, t := range assigned {
+		if t == target {
+			return host
+		}
+	}
+
+	host := fmt.Sprintf("%s-%d.invalid", prefix, len(assigned))
+	assigned[host] = target
+	return host
+}
+
+func isUnixSocket(host string) bool {
+	return strings.HasPrefix(host, unixSocketPrefix)
+}
+
+func isCaddyServer(host string) bool {
+	return strings.HasPrefix(host, caddyServerPrefix)
+}
+
+// hasHost tells whether any of the hosts satisfies is.
+func hasHost(hosts []string, is func(string) bool) bool {
+	for _, h := range hosts {
+		if is(h) {
+			return true
+		}
+	}
+	return false
+}
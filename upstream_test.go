@@ -0,0 +1,19 @@
+package caddylura
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLocalUpstreams(t *testing.T) {
+	u := newLocalUpstreams()
+
+	hosts := u.hosts([]string{"http://users:8080", "unix//run/users.sock", "caddy://internal", "unix//run/users.sock"})
+	assert.Equal(t, []string{"http://users:8080", "http://unix-socket-0.invalid", "http://caddy-server-0.invalid", "http://unix-socket-0.invalid"}, hosts)
+
+	hosts = u.hosts([]string{"unix/roles.sock", "caddy://internal", "caddy://admin"})
+	assert.Equal(t, []string{"http://unix-socket-1.invalid", "http://caddy-server-0.invalid", "http://caddy-server-1.invalid"}, hosts)
+
+	assert.Equal(t, map[string]string{"unix-socket-0.invalid": "/run/users.sock", "unix-socket-1.invalid": "roles.sock"}, u.sockets)
+	assert.Equal(t, map[string]string{"caddy-server-0.invalid": "internal", "caddy-server-1.invalid": "admin"}, u.servers)
+}
@@ -1,6 +1,7 @@
 package caddylura
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 )
@@ -10,6 +11,12 @@ var (
 	caddyPlaceholdersPattern = regexp.MustCompile(`({{\.Resp042_\.(.+?)}})`)
 )
 
+// paramTypes are the named constraints of typed path parameters, e.g. {id:int}.
+var paramTypes = map[string]string{
+	"int":  `-?[0-9]+`,
+	"uuid": `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+}
+
 type paramsSet map[string]interface{}
 
 func newParamsSetFromPattern(subject string) paramsSet {
@@ -36,6 +43,111 @@ func processBackendUrlPattern(subject string, backendParams paramsSet) string {
 	return output
 }
 
+// joinCatchAll drops the slash before the catch-all parameter of the endpoint in a backend URL pattern, since
+// its value starts with a slash: /v1/{rest} gets /v1/ and /v1/a/b rather than /v1// and /v1//a/b.
+func joinCatchAll(subject, catchAll string) string {
+	if catchAll == "" {
+		return subject
+	}
+	return strings.ReplaceAll(subject, "/{"+catchAll+"}", "{"+catchAll+"}")
+}
+
 func applyCaddyPlaceholders(subject string) string {
 	return caddyPlaceholdersPattern.ReplaceAllString(subject, "{${2}}")
 }
+
+// endpointPattern is an endpoint URL pattern translated for lura, whose endpoint patterns only support plain
+// path parameters, along with what the plain parameters cannot express.
+type endpointPattern struct {
+	// path is the URL pattern with plain path parameters only, e.g. /users/{id}.
+	path string
+
+	// constraints holds the constraint of each typed path parameter, compiled so that it has to match
+	// the whole parameter value.
+	constraints map[string]*regexp.Regexp
+
+	// catchAll is the name of the final path parameter matching the rest of the path, if any.
+	catchAll string
+}
+
+// parseEndpointPattern translates the typed path parameters of an endpoint URL pattern, either named types
+// like {id:int} and {ver:uuid} or regular expressions like {name:[a-z0-9-]+}, and its catch-all parameter,
+// like {*rest}, into plain ones such as {id} and {rest}.
+func parseEndpointPattern(subject string) (endpointPattern, error) {
+	var p endpointPattern
+	var sb strings.Builder
+
+	rest := subject
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			break
+		}
+		end := closingBrace(rest, start)
+		if end < 0 {
+			return p, fmt.Errorf("unclosed path parameter in '%s'", subject)
+		}
+
+		param := rest[start+1 : end]
+		sb.WriteString(rest[:start])
+		rest = rest[end+1:]
+
+		if name, ok := strings.CutPrefix(param, "*"); ok {
+			if strings.ContainsRune(name, ':') {
+				return p, fmt.Errorf("catch-all path parameter '{%s}' cannot be constrained", param)
+			}
+			if name == "" || rest != "" || !strings.HasSuffix(sb.String(), "/") {
+				return p, fmt.Errorf("catch-all path parameter '{%s}' must be the last segment of the path", param)
+			}
+			p.catchAll = name
+			sb.WriteString("{" + name + "}")
+			break
+		}
+
+		name, expr, typed := strings.Cut(param, ":")
+		sb.WriteString("{" + name + "}")
+		if !typed {
+			continue
+		}
+
+		if name == "" || expr == "" {
+			return p, fmt.Errorf("invalid path parameter '{%s}'", param)
+		}
+		if t, ok := paramTypes[expr]; ok {
+			expr = t
+		}
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return p, fmt.Errorf("path parameter %s: %w", name, err)
+		}
+
+		if p.constraints == nil {
+			p.constraints = map[string]*regexp.Regexp{}
+		}
+		p.constraints[name] = re
+	}
+	sb.WriteString(rest)
+	p.path = sb.String()
+
+	return p, nil
+}
+
+// closingBrace returns the index of the brace closing the one at start, skipping nested and escaped braces
+// of regular expressions, or -1 if there is none.
+func closingBrace(subject string, start int) int {
+	depth := 0
+	for i := start; i < len(subject); i++ {
+		switch subject[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
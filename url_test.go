@@ -12,3 +12,62 @@ func TestApplyPlaceholders(t *testing.T) {
 
 	assert.Equal(t, expected, actual)
 }
+
+func TestParseEndpointPattern(t *testing.T) {
+	p, err := parseEndpointPattern("/users/{id}/posts")
+	assert.NoError(t, err)
+	assert.Equal(t, endpointPattern{path: "/users/{id}/posts"}, p)
+
+	p, err = parseEndpointPattern("/users/{id:int}/files/{name:[a-z0-9-]{2,8}}/v/{ver:uuid}")
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+	assert.Equal(t, "/users/{id}/files/{name}/v/{ver}", p.path)
+	constraints := p.constraints
+	assert.Len(t, constraints, 3)
+
+	assert.True(t, constraints["id"].MatchString("42"))
+	assert.True(t, constraints["id"].MatchString("-42"))
+	assert.False(t, constraints["id"].MatchString("42a"))
+
+	assert.True(t, constraints["name"].MatchString("my-file"))
+	assert.False(t, constraints["name"].MatchString("my_file"), "the expression must match the whole value")
+	assert.False(t, constraints["name"].MatchString("a"))
+
+	assert.True(t, constraints["ver"].MatchString("123e4567-e89b-12d3-a456-426614174000"))
+	assert.False(t, constraints["ver"].MatchString("123e4567"))
+
+	p, err = parseEndpointPattern(`/tags/{tag:a|b\}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "/tags/{tag}", p.path)
+	assert.True(t, p.constraints["tag"].MatchString("b}"))
+	assert.False(t, p.constraints["tag"].MatchString("ab"))
+
+	_, err = parseEndpointPattern("/users/{id:[0-9}")
+	assert.Error(t, err)
+
+	_, err = parseEndpointPattern("/users/{id:}")
+	assert.Error(t, err)
+}
+
+func TestParseEndpointPatternCatchAll(t *testing.T) {
+	p, err := parseEndpointPattern("/legacy/{tenant:int}/{*rest}")
+	if !assert.NoError(t, err) {
+		t.Fatal()
+	}
+	assert.Equal(t, "/legacy/{tenant}/{rest}", p.path)
+	assert.Equal(t, "rest", p.catchAll)
+	assert.Len(t, p.constraints, 1)
+
+	for _, pattern := range []string{"/legacy/{*rest}/x", "/legacy{*rest}", "/legacy/{*}", "/legacy/{*rest:int}"} {
+		_, err = parseEndpointPattern(pattern)
+		assert.Error(t, err, pattern)
+	}
+}
+
+func TestJoinCatchAll(t *testing.T) {
+	assert.Equal(t, "/v1{rest}", joinCatchAll("/v1/{rest}", "rest"))
+	assert.Equal(t, "{rest}", joinCatchAll("/{rest}", "rest"))
+	assert.Equal(t, "/v1/{id}/{restore}", joinCatchAll("/v1/{id}/{restore}", "rest"))
+	assert.Equal(t, "/v1/{rest}", joinCatchAll("/v1/{rest}", ""))
+}